@@ -9,9 +9,12 @@ require (
 	github.com/fsnotify/fsnotify v1.4.9
 	github.com/hashicorp/go-cleanhttp v0.5.1
 	github.com/hashicorp/go-retryablehttp v0.7.0
+	github.com/miekg/dns v1.1.50
+	github.com/pelletier/go-toml v1.9.5
 	github.com/sirupsen/logrus v1.8.1
 	github.com/tomnomnom/linkheader v0.0.0-20180905144013-02ca5825eb80
-	golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a
+	golang.org/x/crypto v0.11.0
 	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
 	gopkg.in/yaml.v2 v2.4.0
+	software.sslmate.com/src/go-pkcs12 v0.7.3
 )
@@ -0,0 +1,115 @@
+package acmesh
+
+import (
+	. "TLSAutomate/internal"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/x509"
+	"github.com/Al2Klimov/FUeL.go"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+type AcmeSh struct {
+	Numbered
+
+	CertHome string
+}
+
+var _ Input = (*AcmeSh)(nil)
+
+// eccSuffix marks the directories of ECDSA certificates which may coexist with RSA ones for the same domain.
+const eccSuffix = "_ecc"
+
+func (*AcmeSh) Kind() string {
+	return "acme.sh"
+}
+
+func (a *AcmeSh) Ping(context.Context) fuel.ErrorWithStack {
+	_, err := ioutil.ReadDir(a.CertHome)
+	return fuel.AttachStackToError(err, 0)
+}
+
+func (a *AcmeSh) Poll(ctx context.Context, since time.Time) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
+	return PollFiles(ctx, a, since, a.dirs, a.read)
+}
+
+func (a *AcmeSh) read(since time.Time) ([]*x509.Certificate, bool, time.Time, fuel.ErrorWithStack) {
+	certFiles, err := a.certFiles()
+	if err != nil {
+		return nil, false, time.Time{}, err
+	}
+
+	return ReadLeaves(a, since, []string{a.CertHome}, certFiles)
+}
+
+// dirs returns the cert home itself and all domains' directories in it.
+func (a *AcmeSh) dirs() []string {
+	certFiles, err := a.certFiles()
+	if err != nil {
+		ProviderLog(a).WithError(err).Warn("can't list certificates")
+	}
+
+	return append([]string{a.CertHome}, DirsOf(certFiles...)...)
+}
+
+// certFiles returns <domain>/<domain>.cer and <domain>_ecc/<domain>.cer
+// of all directories which contain the respective <domain>.conf.
+func (a *AcmeSh) certFiles() ([]string, fuel.ErrorWithStack) {
+	entries, err := ioutil.ReadDir(a.CertHome)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, fuel.AttachStackToError(err, 0)
+	}
+
+	var certFiles []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		dir := path.Join(a.CertHome, entry.Name())
+		domain := strings.TrimSuffix(entry.Name(), eccSuffix)
+
+		conf, err := ioutil.ReadFile(path.Join(dir, domain+".conf"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				// E.g. ca/, deploy/ or dnsapi/
+				continue
+			}
+
+			return nil, fuel.AttachStackToError(err, 0)
+		}
+
+		vars := parseConf(conf)
+		ProviderLog(a).WithFields(log.Fields{
+			"dir": dir, "domain": vars["Le_Domain"], "alt": vars["Le_Alt"], "key_length": vars["Le_Keylength"],
+		}).Trace("found certificate")
+
+		certFiles = append(certFiles, path.Join(dir, domain+".cer"))
+	}
+
+	return certFiles, nil
+}
+
+// parseConf parses a <domain>.conf consisting of lines like Le_Domain='example.com'.
+func parseConf(conf []byte) map[string]string {
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(conf))
+
+	for scanner.Scan() {
+		if kv := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2); len(kv) == 2 {
+			vars[kv[0]] = strings.Trim(kv[1], `'"`)
+		}
+	}
+
+	return vars
+}
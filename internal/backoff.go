@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"context"
+	"github.com/Al2Klimov/FUeL.go"
+	"time"
+)
+
+// MinBackoff and MaxBackoff limit the delay between retries after transient errors.
+var MinBackoff, MaxBackoff = time.Second, time.Minute
+
+// Backoff retries transient errors with exponential backoff. The zero value starts with MinBackoff.
+type Backoff struct {
+	next time.Duration
+}
+
+// Retry logs err of p and waits for the backoff which it doubles up to MaxBackoff.
+// Returns an error only if ctx is done.
+func (b *Backoff) Retry(ctx context.Context, p Provider, err error, msg string) fuel.ErrorWithStack {
+	if ctx.Err() != nil {
+		return fuel.AttachStackToError(ctx.Err(), 0)
+	}
+
+	if b.next < MinBackoff {
+		b.next = MinBackoff
+	}
+
+	ProviderLog(p).WithError(err).WithField("backoff", b.next).Warn(msg + ", retrying")
+	timer := time.NewTimer(b.next)
+
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		return fuel.AttachStackToError(ctx.Err(), 0)
+	case <-timer.C:
+	}
+
+	if b.next *= 2; b.next > MaxBackoff {
+		b.next = MaxBackoff
+	}
+
+	return nil
+}
+
+// Reset starts over with MinBackoff, e.g. after a success.
+func (b *Backoff) Reset() {
+	b.next = 0
+}
@@ -2,15 +2,20 @@ package bizlogic
 
 import (
 	. "TLSAutomate/internal"
+	. "TLSAutomate/internal/ct"
 	"crypto/md5"
 	"crypto/sha256"
 	"crypto/sha512"
 	"crypto/x509"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/Al2Klimov/FUeL.go"
 	log "github.com/sirupsen/logrus"
+	"path"
+	"regexp"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 )
@@ -18,20 +23,151 @@ import (
 type Config struct {
 	Inputs struct {
 		Traefik []struct {
-			AcmeJson string `yaml:"acme_json"`
+			AcmeJson  string `yaml:"acme_json"`
+			CheckKeys bool   `yaml:"check_keys"`
+			Resolvers map[string]*struct {
+				Ports   *Ports          `yaml:"ports"`
+				Records RecordOverrides `yaml:"records"`
+			} `yaml:"resolvers"`
+			InputSettings `yaml:",inline"`
 		} `yaml:"traefik"`
+		TraefikFile []struct {
+			Path          string `yaml:"path"`
+			InputSettings `yaml:",inline"`
+		} `yaml:"traefik_file"`
+		Certbot []struct {
+			ConfigDir     string `yaml:"config_dir"`
+			InputSettings `yaml:",inline"`
+		} `yaml:"certbot"`
+		Caddy []struct {
+			DataDir       string   `yaml:"data_dir"`
+			Issuers       []string `yaml:"issuers"`
+			InputSettings `yaml:",inline"`
+		} `yaml:"caddy"`
+		AcmeSh []struct {
+			CertHome string `yaml:"cert_home"`
+			// BothKeyTypes defaults to true.
+			BothKeyTypes  *bool `yaml:"both_key_types"`
+			InputSettings `yaml:",inline"`
+		} `yaml:"acme_sh"`
+		Kubernetes []struct {
+			Api           string `yaml:"api"`
+			TokenFile     string `yaml:"token_file"`
+			CaFile        string `yaml:"ca_file"`
+			Namespace     string `yaml:"namespace"`
+			LabelSelector string `yaml:"label_selector"`
+			InputSettings `yaml:",inline"`
+		} `yaml:"kubernetes"`
+		Vault []struct {
+			Address   string `yaml:"address"`
+			CaFile    string `yaml:"ca_file"`
+			TokenFile string `yaml:"token_file"`
+			AppRole   *struct {
+				RoleId       string `yaml:"role_id"`
+				SecretIdFile string `yaml:"secret_id_file"`
+			} `yaml:"approle"`
+			Interval time.Duration `yaml:"interval"`
+			Kv       []struct {
+				Mount string `yaml:"mount"`
+				Path  string `yaml:"path"`
+			} `yaml:"kv"`
+			Pki           []string `yaml:"pki"`
+			InputSettings `yaml:",inline"`
+		} `yaml:"vault"`
+		Scan []struct {
+			Interval time.Duration `yaml:"interval"`
+			Timeout  time.Duration `yaml:"timeout"`
+			Targets  []struct {
+				Address    string `yaml:"address"`
+				ServerName string `yaml:"server_name"`
+				StartTls   string `yaml:"starttls"`
+			} `yaml:"targets"`
+			InputSettings `yaml:",inline"`
+		} `yaml:"scan"`
+		Keystore []struct {
+			File          string `yaml:"file"`
+			PasswordFile  string `yaml:"password_file"`
+			InputSettings `yaml:",inline"`
+		} `yaml:"keystore"`
+		Command []struct {
+			Argv          []string      `yaml:"argv"`
+			Interval      time.Duration `yaml:"interval"`
+			Timeout       time.Duration `yaml:"timeout"`
+			InputSettings `yaml:",inline"`
+		} `yaml:"command"`
+		HaProxy []struct {
+			CrtList       []string `yaml:"crt_list"`
+			Crt           []string `yaml:"crt"`
+			CrtBase       string   `yaml:"crt_base"`
+			InputSettings `yaml:",inline"`
+		} `yaml:"haproxy"`
+		Nginx []struct {
+			Config        string `yaml:"config"`
+			Prefix        string `yaml:"prefix"`
+			InputSettings `yaml:",inline"`
+		} `yaml:"nginx"`
+		Postfix []struct {
+			ConfigDir     string `yaml:"config_dir"`
+			InputSettings `yaml:",inline"`
+		} `yaml:"postfix"`
+		Dovecot []struct {
+			Config        string `yaml:"config"`
+			InputSettings `yaml:",inline"`
+		} `yaml:"dovecot"`
+		Lego []struct {
+			Path          string `yaml:"path"`
+			InputSettings `yaml:",inline"`
+		} `yaml:"lego"`
+		Static struct {
+			Certificates []string `yaml:"certificates"`
+			Files        []string `yaml:"files"`
+			Records      []struct {
+				RecordOverrides `yaml:",inline"`
+				Service         string `yaml:"service"`
+				Data            string `yaml:"data"`
+			} `yaml:"records"`
+			InputSettings `yaml:",inline"`
+		} `yaml:"static"`
 	} `yaml:"inputs"`
-	Ports struct {
-		Tcp []uint16 `yaml:"tcp"`
-		Udp []uint16 `yaml:"udp"`
-	} `yaml:"ports"`
+	Discovery struct {
+		Traefik []struct {
+			Api      string        `yaml:"api"`
+			Interval time.Duration `yaml:"interval"`
+			Stale    Stale         `yaml:"stale"`
+		} `yaml:"traefik"`
+		Docker []struct {
+			Socket      string `yaml:"socket"`
+			LabelPrefix string `yaml:"label_prefix"`
+			Stale       Stale  `yaml:"stale"`
+		} `yaml:"docker"`
+	} `yaml:"discovery"`
+	Watch struct {
+		PollInterval   time.Duration `yaml:"poll_interval"`
+		ReReadInterval time.Duration `yaml:"reread_interval"`
+	} `yaml:"watch"`
+	Domains  Domains `yaml:"domains"`
+	Ports    Ports   `yaml:"ports"`
 	services map[string]struct{}
 	Records  Record `yaml:"records"`
-	Outputs  struct {
+	Ct       []struct {
+		Log      string        `yaml:"log"`
+		Interval time.Duration `yaml:"interval"`
+		Grace    time.Duration `yaml:"grace"`
+	} `yaml:"ct"`
+	Outputs struct {
 		Debug bool `yaml:"debug"`
 		DeSec []struct {
 			Token string `yaml:"token"`
 		} `yaml:"desec"`
+		Rfc2136 []struct {
+			Server string   `yaml:"server"`
+			Zones  []string `yaml:"zones"`
+			Tsig   struct {
+				Name      string `yaml:"name"`
+				Algorithm string `yaml:"algorithm"`
+				Secret    string `yaml:"secret"`
+			} `yaml:"tsig"`
+		} `yaml:"rfc2136"`
 	} `yaml:"outputs"`
 }
 
@@ -59,9 +195,365 @@ func (c *Config) Services() map[string]struct{} {
 	return c.services
 }
 
+// StaticRecords returns the records given as such in the config, defaulting to c.Records per field.
+// They have to be valid.
+func (c *Config) StaticRecords() OutputRecordSet {
+	records := OutputRecordSet{}
+	for _, sr := range c.Inputs.Static.Records {
+		data, _ := hex.DecodeString(sr.Data)
+		rec := OutputRecord{
+			Record: sr.RecordOverrides.Apply(c.Records), Service: strings.TrimSuffix(sr.Service, "."), CertSpec: Base64er(data),
+		}
+
+		records[rec] = struct{}{}
+	}
+
+	return records
+}
+
+type Ports struct {
+	Tcp []uint16 `yaml:"tcp"`
+	Udp []uint16 `yaml:"udp"`
+}
+
+// RecordOverrides override the fields of a Record which are given.
+type RecordOverrides struct {
+	Ttl       *uint32 `yaml:"ttl"`
+	CertUsage *uint8  `yaml:"cert_usage"`
+	Selector  *uint8  `yaml:"selector"`
+	MatchType *uint8  `yaml:"match_type"`
+}
+
+// Apply returns record with the given fields overridden.
+func (ro RecordOverrides) Apply(record Record) Record {
+	if ro.Ttl != nil {
+		record.Ttl = *ro.Ttl
+	}
+
+	if ro.CertUsage != nil {
+		record.CertUsage = *ro.CertUsage
+	}
+
+	if ro.Selector != nil {
+		record.Selector = *ro.Selector
+	}
+
+	if ro.MatchType != nil {
+		record.MatchType = *ro.MatchType
+	}
+
+	return record
+}
+
+// InputSettings are supported by all inputs.
+type InputSettings struct {
+	Domains Domains `yaml:"domains"`
+	Stale   Stale   `yaml:"stale"`
+}
+
+// inputSettings returns is, e.g. of an input's config entry embedding it.
+func (is InputSettings) inputSettings() InputSettings {
+	return is
+}
+
+// Validate returns an error about the first bad setting, if any.
+func (is InputSettings) Validate() error {
+	if err := is.Domains.Validate(); err != nil {
+		return err
+	}
+
+	return is.Stale.Validate()
+}
+
+// Stale tells how to handle an input (or discovery) while it didn't present any certs (or services), yet.
+type Stale struct {
+	// Policy is one of staleBlock (default), staleIgnore and staleLastKnown.
+	Policy string `yaml:"policy"`
+	// Timeout is how long staleLastKnown blocks before using the last known certs.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+const (
+	// staleBlock doesn't process any certs until the input presents its ones.
+	staleBlock = "block"
+	// staleIgnore processes the other inputs' certs until the input presents its ones.
+	staleIgnore = "ignore"
+	// staleLastKnown processes the input's certs from the state DB after Stale.Timeout.
+	// If there are none, it falls back to staleBlock.
+	staleLastKnown = "last_known"
+)
+
+// withDefaults returns s with the default timeout, if needed.
+func (s Stale) withDefaults() Stale {
+	if s.Policy == staleLastKnown && s.Timeout == 0 {
+		s.Timeout = 5 * time.Minute
+	}
+
+	return s
+}
+
+// Validate returns an error about a bad policy or timeout, if any.
+func (s Stale) Validate() error {
+	switch s.Policy {
+	case "", staleBlock, staleIgnore, staleLastKnown:
+	default:
+		return fmt.Errorf("stale policy must be one of %v", []string{staleBlock, staleIgnore, staleLastKnown})
+	}
+
+	if s.Timeout < 0 {
+		return errors.New("stale timeout must not be negative")
+	}
+
+	return nil
+}
+
+// Domains filter SANs via globs like "*.example.com" and, if prefixed with "~", regular expressions of whole SANs.
+// SANs are lower-cased and kept if they match any include pattern (if any given) and no exclude one.
+type Domains struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// Validate returns an error about the first bad pattern, if any.
+func (d Domains) Validate() error {
+	for _, patterns := range [2][]string{d.Include, d.Exclude} {
+		for _, pattern := range patterns {
+			if _, err := compileDomainPattern(pattern); err != nil {
+				return fmt.Errorf("bad domain pattern %q: %s", pattern, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
 type DB struct {
 	MaybeWritten OutputRecordSet
 	Written      OutputRecordSet
+	// Inputs are the last known DER certs of the inputs with the stale policy staleLastKnown.
+	Inputs map[string][][]byte
+	// Discoveries are the last known services of the discoveries with the stale policy staleLastKnown.
+	Discoveries map[string]HostServices
+}
+
+type sanAndAlgo struct {
+	san     string
+	algo    x509.PublicKeyAlgorithm
+	profile *profile
+}
+
+// certGroup groups the inputs' certs by how to translate them to records.
+type certGroup struct {
+	// profile is the default one if nil.
+	profile *profile
+	// byKeyType keeps the latest cert per key type instead of only the latest one per SAN.
+	byKeyType bool
+}
+
+// profile overrides the services and record parameters for certain inputs' certs.
+type profile struct {
+	// services are the default ones if nil.
+	services map[string]struct{}
+	record   Record
+}
+
+// newProfile overrides cfg's ports and records with the given ones, if any.
+func newProfile(cfg *Config, ports *Ports, records RecordOverrides) *profile {
+	p := &profile{record: records.Apply(cfg.Records)}
+
+	if ports != nil {
+		p.services = (&Config{Ports: *ports}).Services()
+	}
+
+	return p
+}
+
+// domainFilter is compiled Domains.
+type domainFilter struct {
+	include []func(string) bool
+	exclude []func(string) bool
+}
+
+// newDomainFilter compiles d which has to be valid. It returns nil if d is empty.
+func newDomainFilter(d Domains) *domainFilter {
+	if len(d.Include)+len(d.Exclude) < 1 {
+		return nil
+	}
+
+	df := &domainFilter{}
+	for _, patterns := range []struct {
+		from []string
+		to   *[]func(string) bool
+	}{{d.Include, &df.include}, {d.Exclude, &df.exclude}} {
+		for _, pattern := range patterns.from {
+			match, _ := compileDomainPattern(pattern)
+			*patterns.to = append(*patterns.to, match)
+		}
+	}
+
+	return df
+}
+
+// compileDomainPattern compiles a glob or a "~" prefixed regular expression which has to match a whole SAN.
+// The returned function matches SANs case-insensitively, as lower-case.
+func compileDomainPattern(pattern string) (func(string) bool, error) {
+	if strings.HasPrefix(pattern, "~") {
+		// On its own first, so that e.g. "a)|(b" can't escape the anchors.
+		re, err := regexp.Compile(pattern[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		re = regexp.MustCompile("^(?:" + re.String() + ")$")
+
+		return func(san string) bool {
+			return re.MatchString(strings.ToLower(san))
+		}, nil
+	}
+
+	pattern = strings.ToLower(pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	return func(san string) bool {
+		ok, _ := path.Match(pattern, strings.ToLower(san))
+		return ok
+	}, nil
+}
+
+// wants tells whether to keep the given SAN.
+func (df *domainFilter) wants(san string) bool {
+	if len(df.include) > 0 {
+		found := false
+		for _, match := range df.include {
+			if match(san) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	for _, match := range df.exclude {
+		if match(san) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// inputOptions are per-input settings of the pipeline.
+type inputOptions struct {
+	// profile is the default one if nil.
+	profile *profile
+	// byKeyType see certGroup.
+	byKeyType bool
+	// domains are the input's and the global domain filters. Nil ones are no-ops.
+	domains []*domainFilter
+	// key identifies the input in DB.Inputs.
+	key   string
+	stale Stale
+}
+
+type discoveryOptions struct {
+	// key identifies the discovery in DB.Discoveries.
+	key   string
+	stale Stale
+}
+
+// ctWatchlist knows our domains and keys for CT monitoring.
+type ctWatchlist struct {
+	sync.RWMutex
+
+	// domains are lower-case, nil while unknown.
+	domains map[string]struct{}
+	// keys are SHA-256 sums of all inputs' certs' public keys.
+	keys map[[sha256.Size]byte]struct{}
+}
+
+var _ Watchlist = (*ctWatchlist)(nil)
+
+func (w *ctWatchlist) Ready() bool {
+	w.RLock()
+	defer w.RUnlock()
+
+	return w.domains != nil
+}
+
+func (w *ctWatchlist) Unknown(cert *x509.Certificate) []string {
+	w.RLock()
+	defer w.RUnlock()
+
+	if _, ok := w.keys[sha256.Sum256(cert.RawSubjectPublicKeyInfo)]; ok {
+		return nil
+	}
+
+	var ours []string
+	for _, san := range cert.DNSNames {
+		if w.covers(strings.ToLower(san)) {
+			ours = append(ours, san)
+		}
+	}
+
+	return ours
+}
+
+// covers tells whether the SAN covers any of our domains or vice versa, considering wildcards.
+func (w *ctWatchlist) covers(san string) bool {
+	if _, ok := w.domains[san]; ok {
+		return true
+	}
+
+	if strings.HasPrefix(san, "*.") {
+		parent := san[1:]
+		for domain := range w.domains {
+			if strings.HasSuffix(domain, parent) && !strings.Contains(strings.TrimSuffix(domain, parent), ".") {
+				return true
+			}
+		}
+	} else if i := strings.IndexByte(san, '.'); i >= 0 {
+		if _, ok := w.domains["*"+san[i:]]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setKeys remembers the public keys of the given certs.
+func (w *ctWatchlist) setKeys(certs map[certGroup][]*x509.Certificate) {
+	keys := map[[sha256.Size]byte]struct{}{}
+	for _, certs := range certs {
+		for _, cert := range certs {
+			keys[sha256.Sum256(cert.RawSubjectPublicKeyInfo)] = struct{}{}
+		}
+	}
+
+	w.Lock()
+	w.keys = keys
+	w.Unlock()
+}
+
+// setDomains remembers the domains of the given records, e.g. example.com of _443._tcp.example.com.
+func (w *ctWatchlist) setDomains(records OutputRecordSet) {
+	domains := map[string]struct{}{}
+	for record := range records {
+		labels := strings.Split(strings.ToLower(record.Service), ".")
+		for len(labels) > 1 && (strings.HasPrefix(labels[0], "_") || labels[0] == "*" && strings.HasPrefix(labels[1], "_")) {
+			labels = labels[1:]
+		}
+
+		domains[strings.Join(labels, ".")] = struct{}{}
+	}
+
+	w.Lock()
+	w.domains = domains
+	w.Unlock()
 }
 
 type certsSet struct {
@@ -69,6 +561,15 @@ type certsSet struct {
 
 	certs      []*x509.Certificate
 	lastUpdate time.Time
+	options    inputOptions
+}
+
+type servicesSet struct {
+	sync.RWMutex
+
+	services   HostServices
+	lastUpdate time.Time
+	options    discoveryOptions
 }
 
 var concurrency = 2 * int64(runtime.GOMAXPROCS(0))
@@ -110,8 +611,10 @@ func uniqCerts(certs []*x509.Certificate) []*x509.Certificate {
 
 NotUniqCerts:
 	for _, cert := range certs {
-		for _, uniq := range uniqs {
+		for i, uniq := range uniqs {
 			if cert.Equal(uniq) {
+				// Different inputs' domain filters may have left different SANs.
+				uniqs[i] = mergeSans(uniq, cert)
 				continue NotUniqCerts
 			}
 		}
@@ -122,6 +625,64 @@ NotUniqCerts:
 	return uniqs
 }
 
+// mergeSans returns a copy of into with the SANs of both if from has any more of them.
+func mergeSans(into, from *x509.Certificate) *x509.Certificate {
+	have := make(map[string]struct{}, len(into.DNSNames))
+	for _, san := range into.DNSNames {
+		have[san] = struct{}{}
+	}
+
+	var merged *x509.Certificate
+	for _, san := range from.DNSNames {
+		if _, ok := have[san]; !ok {
+			if merged == nil {
+				copied := *into
+				copied.DNSNames = append([]string(nil), into.DNSNames...)
+				merged = &copied
+			}
+
+			merged.DNSNames = append(merged.DNSNames, san)
+		}
+	}
+
+	if merged == nil {
+		return into
+	}
+
+	return merged
+}
+
+// filterSans narrows the certs' SANs down to the ones all filters want, on copies of the certs.
+func filterSans(logger log.FieldLogger, certs []*x509.Certificate, filters []*domainFilter) []*x509.Certificate {
+	var filtered []*x509.Certificate
+
+	for _, cert := range certs {
+		var sans []string
+
+	SANs:
+		for _, san := range cert.DNSNames {
+			for _, df := range filters {
+				if df != nil && !df.wants(san) {
+					logger.WithFields(describeCert(cert)).WithField("san", san).Trace("SAN filtered out")
+					continue SANs
+				}
+			}
+
+			sans = append(sans, san)
+		}
+
+		if len(sans) < len(cert.DNSNames) {
+			copied := *cert
+			copied.DNSNames = sans
+			cert = &copied
+		}
+
+		filtered = append(filtered, cert)
+	}
+
+	return filtered
+}
+
 func filterCerts(certs []*x509.Certificate) []*x509.Certificate {
 	var filtered []*x509.Certificate
 	now := time.Now()
@@ -0,0 +1,74 @@
+package bizlogic
+
+import (
+	"crypto/x509"
+	log "github.com/sirupsen/logrus"
+	"reflect"
+	"testing"
+)
+
+func TestFilterSans(t *testing.T) {
+	sans := []string{"example.com", "www.example.com", "*.example.com", "a.b.example.com", "Customer-1.Example.com"}
+
+	for _, tc := range []struct {
+		name     string
+		filters  []Domains
+		expected []string
+	}{
+		{"none", nil, sans},
+		{"empty", []Domains{{}}, sans},
+		{
+			"glob", []Domains{{Include: []string{"*.example.com"}}},
+			// Also the wildcard itself and multiple labels
+			[]string{"www.example.com", "*.example.com", "a.b.example.com", "Customer-1.Example.com"},
+		},
+		{"glob, upper-case", []Domains{{Include: []string{"*.EXAMPLE.COM"}, Exclude: []string{"*.*.example.com"}}},
+			[]string{"www.example.com", "*.example.com", "Customer-1.Example.com"}},
+		{"glob, wildcard only", []Domains{{Include: []string{`\*.example.com`}}}, []string{"*.example.com"}},
+		{"regex, anchored", []Domains{{Include: []string{`~example\.com`}}}, []string{"example.com"}},
+		{"regex, alternatives", []Domains{{Include: []string{`~www\.example\.com|example\.com`}}},
+			[]string{"example.com", "www.example.com"}},
+		{"regex, lower-case", []Domains{{Exclude: []string{`~customer-[0-9]+\.example\.com`}}},
+			[]string{"example.com", "www.example.com", "*.example.com", "a.b.example.com"}},
+		{"regex, no wildcards", []Domains{{Include: []string{`~[a-z]+\.example\.com`}}}, []string{"www.example.com"}},
+		{"regex, wildcard", []Domains{{Exclude: []string{`~\*\..*`}}},
+			[]string{"example.com", "www.example.com", "a.b.example.com", "Customer-1.Example.com"}},
+		{
+			"input and global", []Domains{{Include: []string{"*.example.com"}}, {Exclude: []string{"*.*.example.com"}}},
+			[]string{"www.example.com", "*.example.com", "Customer-1.Example.com"},
+		},
+		{"nothing left", []Domains{{Include: []string{"*.example.org"}}}, nil},
+	} {
+		var filters []*domainFilter
+		for _, d := range tc.filters {
+			if err := d.Validate(); err != nil {
+				t.Fatalf("%s: %s", tc.name, err.Error())
+			}
+
+			filters = append(filters, newDomainFilter(d))
+		}
+
+		cert := &x509.Certificate{DNSNames: sans}
+		filtered := filterSans(log.StandardLogger(), []*x509.Certificate{cert}, filters)
+
+		if len(filtered) != 1 {
+			t.Fatalf("%s: expected 1 cert, got %d", tc.name, len(filtered))
+		}
+
+		if !reflect.DeepEqual(filtered[0].DNSNames, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, filtered[0].DNSNames)
+		}
+
+		if !reflect.DeepEqual(cert.DNSNames, sans) {
+			t.Errorf("%s: expected the original cert to be unchanged, got %v", tc.name, cert.DNSNames)
+		}
+	}
+}
+
+func TestDomains_Validate(t *testing.T) {
+	for _, pattern := range []string{"[", "~(", "~a)(b", "~a)|(b"} {
+		if err := (Domains{Include: []string{pattern}}).Validate(); err == nil {
+			t.Errorf("%q: expected an error", pattern)
+		}
+	}
+}
@@ -2,60 +2,330 @@ package bizlogic
 
 import (
 	. "TLSAutomate/internal"
+	. "TLSAutomate/internal/acme-sh"
+	. "TLSAutomate/internal/caddy"
+	. "TLSAutomate/internal/certbot"
+	. "TLSAutomate/internal/command"
+	. "TLSAutomate/internal/ct"
 	. "TLSAutomate/internal/debug"
 	. "TLSAutomate/internal/desec"
+	. "TLSAutomate/internal/docker"
+	. "TLSAutomate/internal/dovecot"
+	. "TLSAutomate/internal/haproxy"
+	. "TLSAutomate/internal/keystore"
+	. "TLSAutomate/internal/kubernetes"
+	. "TLSAutomate/internal/lego"
+	. "TLSAutomate/internal/nginx"
+	. "TLSAutomate/internal/postfix"
+	. "TLSAutomate/internal/rfc2136"
+	. "TLSAutomate/internal/scan"
+	. "TLSAutomate/internal/static"
 	. "TLSAutomate/internal/traefik"
+	. "TLSAutomate/internal/vault"
 	"context"
 	"crypto/sha256"
 	"crypto/sha512"
 	"crypto/x509"
+	"encoding/json"
 	"fmt"
 	"github.com/Al2Klimov/DullDB"
 	"github.com/Al2Klimov/FUeL.go"
 	log "github.com/sirupsen/logrus"
+	"net"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 func EverythingElse(ctx context.Context, cfg *Config, db string) fuel.ErrorWithStack {
-	inputs, outputs, getBytes, hashBytes := setup(cfg)
+	inputs, options, discoveries, discoveryOpts, outputs, ctLogs, getBytes, hashBytes := setup(cfg)
 
-	if err := ping(ctx, inputs, outputs); err != nil {
+	if err := ping(ctx, inputs, discoveries, outputs, ctLogs); err != nil {
 		return err
 	}
 
 	g := fuel.NewErrorGroup(ctx, 0)
 	certsSets := make([]certsSet, len(inputs))
+	servicesSets := make([]servicesSet, len(discoveries))
 	certsChanged := make(chan struct{}, 1)
+	started := time.Now()
+	watchlist := &ctWatchlist{}
 
 	log.Info("polling inputs")
 
 	for i, in := range inputs {
 		in := in
 		ac := &certsSets[i]
+		ac.options = options[in]
+
+		if ac.options.stale.Policy == staleLastKnown {
+			// Process the last known certs even if no input ever presents any.
+			timer := time.AfterFunc(ac.options.stale.Timeout, func() {
+				select {
+				case certsChanged <- struct{}{}:
+				default:
+				}
+			})
+			defer timer.Stop()
+		}
 
 		g.Go(1, func(ctx context.Context) fuel.ErrorWithStack {
 			return poll(ctx, in, ac, certsChanged)
 		})
 	}
 
+	for i, ds := range discoveries {
+		ds := ds
+		ss := &servicesSets[i]
+		ss.options = discoveryOpts[ds]
+
+		if ss.options.stale.Policy == staleLastKnown {
+			// Process the last known services even if the discovery never presents any.
+			timer := time.AfterFunc(ss.options.stale.Timeout, func() {
+				select {
+				case certsChanged <- struct{}{}:
+				default:
+				}
+			})
+			defer timer.Stop()
+		}
+
+		g.Go(1, func(ctx context.Context) fuel.ErrorWithStack {
+			return discover(ctx, ds, ss, certsChanged)
+		})
+	}
+
+	for _, cl := range ctLogs {
+		cl := cl
+
+		g.Go(1, func(ctx context.Context) fuel.ErrorWithStack {
+			return cl.Monitor(ctx, watchlist)
+		})
+	}
+
 	g.Go(1, func(ctx context.Context) fuel.ErrorWithStack {
-		return processUpdates(ctx, certsChanged, certsSets, outputs, inputs, db, getBytes, hashBytes, cfg)
+		return processUpdates(
+			ctx, certsChanged, certsSets, servicesSets, outputs, inputs, discoveries, db, getBytes, hashBytes, cfg, started,
+			watchlist,
+		)
 	})
 
 	return g.Wait()
 }
 
 func setup(cfg *Config) (
-	inputs []Input, outputs []Output,
-	getBytes func(*x509.Certificate) ([]byte, fuel.ErrorWithStack), hashBytes func([]byte) []byte,
+	inputs []Input, options map[Input]inputOptions, discoveries []Discovery,
+	discoveryOpts map[Discovery]discoveryOptions, outputs []Output, ctLogs []*CtLog, getBytes func(*x509.Certificate) ([]byte, fuel.ErrorWithStack), hashBytes func([]byte) []byte,
 ) {
 	getBytes = selectors[cfg.Records.Selector]
 	hashBytes = matchTypes[cfg.Records.MatchType]
 
+	FileWatching.PollInterval = cfg.Watch.PollInterval
+	FileWatching.ReReadInterval = cfg.Watch.ReReadInterval
+
+	options = map[Input]inputOptions{}
+	globalDomains := newDomainFilter(cfg.Domains)
+
+	addInput := func(in Input, entry interface{ inputSettings() InputSettings }) {
+		settings := entry.inputSettings()
+		opts := inputOptions{
+			domains: []*domainFilter{newDomainFilter(settings.Domains), globalDomains},
+			key:     configKey(in, entry),
+			stale:   settings.Stale.withDefaults(),
+		}
+
+		inputs = append(inputs, in)
+		options[in] = opts
+	}
+
+	discoveryOpts = map[Discovery]discoveryOptions{}
+
+	addDiscovery := func(ds Discovery, entry interface{}, stale Stale) {
+		discoveries = append(discoveries, ds)
+		discoveryOpts[ds] = discoveryOptions{key: configKey(ds, entry), stale: stale.withDefaults()}
+	}
+
 	for i, tr := range cfg.Inputs.Traefik {
-		inputs = append(inputs, &Traefik{Numbered{i + 1}, tr.AcmeJson})
+		if len(tr.Resolvers) < 1 {
+			addInput(&Traefik{
+				Numbered: Numbered{Nr: i + 1}, AcmeJson: tr.AcmeJson, CheckKeys: tr.CheckKeys,
+			}, tr)
+			continue
+		}
+
+		resolvers := make([]string, 0, len(tr.Resolvers))
+		for resolver := range tr.Resolvers {
+			resolvers = append(resolvers, resolver)
+		}
+
+		sort.Strings(resolvers)
+
+		// One input per resolver, so that its certs can get their own profile.
+		for _, resolver := range resolvers {
+			t := &Traefik{
+				Numbered: Numbered{Nr: i + 1}, AcmeJson: tr.AcmeJson, CheckKeys: tr.CheckKeys, Resolvers: []string{resolver},
+			}
+			addInput(t, tr)
+
+			opts := options[t]
+			opts.key += " " + resolver
+
+			if res := tr.Resolvers[resolver]; res != nil {
+				opts.profile = newProfile(cfg, res.Ports, res.Records)
+			}
+
+			options[t] = opts
+		}
+	}
+
+	for i, tf := range cfg.Inputs.TraefikFile {
+		addInput(&TraefikFile{Numbered: Numbered{Nr: i + 1}, Path: tf.Path}, tf)
+	}
+
+	for i, cb := range cfg.Inputs.Certbot {
+		addInput(&Certbot{Numbered: Numbered{Nr: i + 1}, ConfigDir: cb.ConfigDir}, cb)
+	}
+
+	for i, cd := range cfg.Inputs.Caddy {
+		addInput(&Caddy{Numbered: Numbered{Nr: i + 1}, DataDir: cd.DataDir, Issuers: cd.Issuers}, cd)
+	}
+
+	for i, as := range cfg.Inputs.AcmeSh {
+		a := &AcmeSh{Numbered: Numbered{Nr: i + 1}, CertHome: as.CertHome}
+		addInput(a, as)
+
+		opts := options[a]
+		opts.byKeyType = as.BothKeyTypes == nil || *as.BothKeyTypes
+		options[a] = opts
+	}
+
+	for i, k8s := range cfg.Inputs.Kubernetes {
+		addInput(&Kubernetes{
+			Numbered:      Numbered{Nr: i + 1},
+			Api:           k8s.Api,
+			TokenFile:     k8s.TokenFile,
+			CaFile:        k8s.CaFile,
+			Namespace:     k8s.Namespace,
+			LabelSelector: k8s.LabelSelector,
+		}, k8s)
+	}
+
+	for i, vt := range cfg.Inputs.Vault {
+		v := &Vault{
+			Numbered:  Numbered{Nr: i + 1},
+			Address:   vt.Address,
+			CaFile:    vt.CaFile,
+			TokenFile: vt.TokenFile,
+			Interval:  vt.Interval,
+			Pki:       vt.Pki,
+		}
+
+		if vt.AppRole != nil {
+			v.AppRole = &AppRole{RoleId: vt.AppRole.RoleId, SecretIdFile: vt.AppRole.SecretIdFile}
+		}
+
+		if v.Interval == 0 {
+			v.Interval = time.Hour
+		}
+
+		for _, kv := range vt.Kv {
+			v.Kv = append(v.Kv, KvPath{Mount: kv.Mount, Path: kv.Path})
+		}
+
+		addInput(v, vt)
+	}
+
+	for i, sc := range cfg.Inputs.Scan {
+		s := &Scan{Numbered: Numbered{Nr: i + 1}, Interval: sc.Interval, Timeout: sc.Timeout}
+
+		if s.Interval == 0 {
+			s.Interval = time.Hour
+		}
+
+		if s.Timeout == 0 {
+			s.Timeout = 30 * time.Second
+		}
+
+		for _, target := range sc.Targets {
+			s.Targets = append(s.Targets, Target{
+				Address: target.Address, ServerName: target.ServerName, StartTls: target.StartTls,
+			})
+		}
+
+		addInput(s, sc)
+	}
+
+	for i, ks := range cfg.Inputs.Keystore {
+		addInput(&Keystore{Numbered: Numbered{Nr: i + 1}, File: ks.File, PasswordFile: ks.PasswordFile}, ks)
+	}
+
+	for i, cm := range cfg.Inputs.Command {
+		c := &Command{Numbered: Numbered{Nr: i + 1}, Argv: cm.Argv, Interval: cm.Interval, Timeout: cm.Timeout}
+
+		if c.Interval == 0 {
+			c.Interval = time.Hour
+		}
+
+		if c.Timeout == 0 {
+			c.Timeout = time.Minute
+		}
+
+		addInput(c, cm)
+	}
+
+	for i, hp := range cfg.Inputs.HaProxy {
+		addInput(&HaProxy{
+			Numbered: Numbered{Nr: i + 1}, CrtLists: hp.CrtList, Crts: hp.Crt, CrtBase: hp.CrtBase,
+		}, hp)
+	}
+
+	for i, ng := range cfg.Inputs.Nginx {
+		// Both reads the certificates and discovers where they're served.
+		n := &Nginx{Numbered: Numbered{Nr: i + 1}, Config: ng.Config, Prefix: ng.Prefix}
+		addInput(n, ng)
+		addDiscovery(n, ng, ng.Stale)
+	}
+
+	for i, pf := range cfg.Inputs.Postfix {
+		p := &Postfix{Numbered: Numbered{Nr: i + 1}, ConfigDir: pf.ConfigDir}
+		addInput(p, pf)
+		addDiscovery(p, pf, pf.Stale)
+	}
+
+	for i, dc := range cfg.Inputs.Dovecot {
+		d := &Dovecot{Numbered: Numbered{Nr: i + 1}, Config: dc.Config}
+		addInput(d, dc)
+		addDiscovery(d, dc, dc.Stale)
+	}
+
+	for i, lg := range cfg.Inputs.Lego {
+		addInput(&Lego{Numbered: Numbered{Nr: i + 1}, Path: lg.Path}, lg)
+	}
+
+	if st := &cfg.Inputs.Static; len(st.Certificates)+len(st.Files)+len(st.Records) > 0 {
+		// Even for records only, not to wait for other inputs which may not exist
+		addInput(&Static{Numbered: Numbered{Nr: 1}, Pem: st.Certificates, Files: st.Files}, st)
+	}
+
+	for i, tr := range cfg.Discovery.Traefik {
+		ta := &TraefikApi{Numbered: Numbered{Nr: i + 1}, Api: tr.Api, Interval: tr.Interval}
+
+		if ta.Interval == 0 {
+			ta.Interval = time.Minute
+		}
+
+		addDiscovery(ta, tr, tr.Stale)
+	}
+
+	for i, dk := range cfg.Discovery.Docker {
+		d := &Docker{Numbered: Numbered{Nr: i + 1}, Socket: dk.Socket, LabelPrefix: dk.LabelPrefix}
+
+		if d.Socket == "" {
+			d.Socket = "/var/run/docker.sock"
+		}
+
+		addDiscovery(d, dk, dk.Stale)
 	}
 
 	if cfg.Outputs.Debug {
@@ -63,12 +333,59 @@ func setup(cfg *Config) (
 	}
 
 	for i, ds := range cfg.Outputs.DeSec {
-		outputs = append(outputs, &DeSEC{Numbered: Numbered{i + 1}, Token: ds.Token})
+		outputs = append(outputs, &DeSEC{Numbered: Numbered{Nr: i + 1}, Token: ds.Token})
+	}
+
+	for i, rf := range cfg.Outputs.Rfc2136 {
+		r := &Rfc2136{
+			Numbered:      Numbered{Nr: i + 1},
+			Server:        rf.Server,
+			Zones:         rf.Zones,
+			TsigName:      rf.Tsig.Name,
+			TsigAlgorithm: rf.Tsig.Algorithm,
+			TsigSecret:    rf.Tsig.Secret,
+		}
+
+		if _, _, err := net.SplitHostPort(r.Server); err != nil {
+			r.Server = net.JoinHostPort(r.Server, "53")
+		}
+
+		if r.TsigAlgorithm == "" {
+			r.TsigAlgorithm = "hmac-sha256"
+		}
+
+		outputs = append(outputs, r)
 	}
+
+	for i, cc := range cfg.Ct {
+		cl := &CtLog{Numbered: Numbered{Nr: i + 1}, Log: cc.Log, Interval: cc.Interval, Grace: cc.Grace}
+
+		if cl.Interval == 0 {
+			cl.Interval = time.Minute
+		}
+
+		if cl.Grace == 0 {
+			cl.Grace = time.Hour
+		}
+
+		ctLogs = append(ctLogs, cl)
+	}
+
 	return
 }
 
-func ping(ctx context.Context, inputs []Input, outputs []Output) fuel.ErrorWithStack {
+// configKey identifies p in the state DB by its number and config entry,
+// so that e.g. renumbered inputs don't get other ones' last known certs.
+func configKey(p Provider, entry interface{}) string {
+	j, _ := json.Marshal(entry)
+	hash := sha256.Sum256(j)
+
+	return fmt.Sprintf("%s #%d %x", p.Kind(), p.Number(), hash[:4])
+}
+
+func ping(
+	ctx context.Context, inputs []Input, discoveries []Discovery, outputs []Output, ctLogs []*CtLog,
+) fuel.ErrorWithStack {
 	log.Info("pinging I/Os")
 	g := fuel.NewErrorGroup(ctx, concurrency)
 
@@ -76,10 +393,18 @@ func ping(ctx context.Context, inputs []Input, outputs []Output) fuel.ErrorWithS
 		g.Go(1, in.Ping)
 	}
 
+	for _, ds := range discoveries {
+		g.Go(1, ds.Ping)
+	}
+
 	for _, out := range outputs {
 		g.Go(1, out.Ping)
 	}
 
+	for _, cl := range ctLogs {
+		g.Go(1, cl.Ping)
+	}
+
 	return g.Wait()
 }
 
@@ -110,29 +435,81 @@ func poll(ctx context.Context, from Input, to *certsSet, notify chan<- struct{})
 	}
 }
 
+func discover(ctx context.Context, from Discovery, to *servicesSet, notify chan<- struct{}) fuel.ErrorWithStack {
+	var lastUpdate time.Time
+
+	for {
+		services, currentUpdate, err := from.Discover(ctx, lastUpdate)
+		if err != nil {
+			return err
+		}
+
+		ProviderLog(from).WithFields(log.Fields{
+			"hosts": len(services), "as_of": currentUpdate, "since": lastUpdate,
+		}).Info("got new services set from discovery")
+
+		lastUpdate = currentUpdate
+
+		to.Lock()
+		to.services = services
+		to.lastUpdate = currentUpdate
+		to.Unlock()
+
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
 func processUpdates(
-	ctx context.Context, trigger <-chan struct{}, from []certsSet, to []Output, inputs []Input, db string,
+	ctx context.Context, trigger <-chan struct{}, from []certsSet, discovered []servicesSet, to []Output,
+	inputs []Input, discoveries []Discovery, db string,
 	getBytes func(*x509.Certificate) ([]byte, fuel.ErrorWithStack), hashBytes func([]byte) []byte, cfg *Config,
+	started time.Time, watchlist *ctWatchlist,
 ) fuel.ErrorWithStack {
 	loggedFailures := map[[sha256.Size]byte]struct{}{}
 	lastHash := new([sha512.Size]byte)
 	*lastHash = sha512.Sum512(nil)
 
+	lastKnown, lastKnownServices, err := loadLastKnown(db)
+	if err != nil {
+		return err
+	}
+
+	remembered := map[string]time.Time{}
+	rememberedServices := map[string]time.Time{}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return fuel.AttachStackToError(ctx.Err(), 0)
 		case <-trigger:
-			certs, ok := collect(from, inputs)
+			if err := remember(db, from, discovered, remembered, rememberedServices); err != nil {
+				return err
+			}
+
+			certs, ok := collect(from, inputs, lastKnown, started)
 			if !ok {
 				break
 			}
 
-			records, ok := assemble(certs, loggedFailures, getBytes, hashBytes, cfg, lastHash)
+			watchlist.setKeys(certs)
+
+			services, added, ok := collectServices(discovered, discoveries, lastKnownServices, started)
 			if !ok {
 				break
 			}
 
+			records, changed := assemble(certs, services, added, loggedFailures, getBytes, hashBytes, cfg, lastHash)
+
+			// Even if unchanged, e.g. initially none, so that CT monitoring starts.
+			watchlist.setDomains(records)
+
+			if !changed {
+				break
+			}
+
 			if err := apply(ctx, to, db, records); err != nil {
 				return err
 			}
@@ -140,47 +517,296 @@ func processUpdates(
 	}
 }
 
-func collect(from []certsSet, inputs []Input) ([]*x509.Certificate, bool) {
-	var certs []*x509.Certificate
+// collect returns all inputs' certs by group.
+// Inputs which didn't present any certs, yet, are handled as their stale policy says.
+func collect(
+	from []certsSet, inputs []Input, lastKnown map[string][]*x509.Certificate, started time.Time,
+) (map[certGroup][]*x509.Certificate, bool) {
+	certs := map[certGroup][]*x509.Certificate{}
 	for i := range from {
 		cs := &from[i]
 		cs.RLock()
 
-		if cs.lastUpdate == (time.Time{}) {
-			cs.RUnlock()
-			in := inputs[i]
+		in := inputs[i]
+		current := cs.certs
 
-			ProviderLog(in).Debug("input didn't present any certs by now - not processing, yet")
-			return nil, false
+		if cs.lastUpdate == (time.Time{}) {
+			stale := cs.options.stale
+			known, hasKnown := lastKnown[cs.options.key]
+
+			switch {
+			case stale.Policy == staleIgnore:
+				ProviderLog(in).Debug("input didn't present any certs by now - ignoring it for now")
+				cs.RUnlock()
+				continue
+			case stale.Policy == staleLastKnown && time.Since(started) >= stale.Timeout && hasKnown:
+				current = known
+
+				ProviderLog(in).WithField("amount", len(current)).
+					Debug("input didn't present any certs by now - using last known ones")
+			case stale.Policy == staleLastKnown && time.Since(started) >= stale.Timeout:
+				cs.RUnlock()
+
+				ProviderLog(in).Warn(
+					"input didn't present any certs by now and there are no last known ones - not processing, yet",
+				)
+				return nil, false
+			default:
+				cs.RUnlock()
+
+				ProviderLog(in).Debug("input didn't present any certs by now - not processing, yet")
+				return nil, false
+			}
 		}
 
-		certs = append(certs, cs.certs...)
+		group := certGroup{cs.options.profile, cs.options.byKeyType}
+		certs[group] = append(certs[group], filterSans(ProviderLog(in), current, cs.options.domains)...)
 		cs.RUnlock()
 	}
 
 	return certs, true
 }
 
+// loadLastKnown returns the certs and services remembered by remember.
+func loadLastKnown(db string) (map[string][]*x509.Certificate, map[string]HostServices, fuel.ErrorWithStack) {
+	var state DB
+	if err := dulldb.Select(db, &state); err != nil {
+		return nil, nil, err
+	}
+
+	lastKnown := make(map[string][]*x509.Certificate, len(state.Inputs))
+	for key, ders := range state.Inputs {
+		// Even if empty, the input presented them.
+		lastKnown[key] = make([]*x509.Certificate, 0, len(ders))
+
+		for _, der := range ders {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				log.WithError(err).WithField("input", key).Warn("can't parse last known cert")
+				continue
+			}
+
+			lastKnown[key] = append(lastKnown[key], cert)
+		}
+	}
+
+	lastKnownServices := state.Discoveries
+	if lastKnownServices == nil {
+		lastKnownServices = map[string]HostServices{}
+	}
+
+	return lastKnown, lastKnownServices, nil
+}
+
+// remember saves the certs (services) of the inputs (discoveries) with the stale policy staleLastKnown
+// into the state DB unless they didn't change since the last call.
+func remember(
+	db string, from []certsSet, discovered []servicesSet, remembered, rememberedServices map[string]time.Time,
+) fuel.ErrorWithStack {
+	changed := map[string][][]byte{}
+	updates := map[string]time.Time{}
+
+	for i := range from {
+		cs := &from[i]
+		cs.RLock()
+
+		if key := cs.options.key; cs.options.stale.Policy == staleLastKnown &&
+			cs.lastUpdate != (time.Time{}) && cs.lastUpdate != remembered[key] {
+			ders := make([][]byte, 0, len(cs.certs))
+			for _, cert := range cs.certs {
+				ders = append(ders, cert.Raw)
+			}
+
+			changed[key] = ders
+			updates[key] = cs.lastUpdate
+		}
+
+		cs.RUnlock()
+	}
+
+	changedServices := map[string]HostServices{}
+	servicesUpdates := map[string]time.Time{}
+
+	for i := range discovered {
+		ss := &discovered[i]
+		ss.RLock()
+
+		if key := ss.options.key; ss.options.stale.Policy == staleLastKnown &&
+			ss.lastUpdate != (time.Time{}) && ss.lastUpdate != rememberedServices[key] {
+			changedServices[key] = ss.services
+			servicesUpdates[key] = ss.lastUpdate
+		}
+
+		ss.RUnlock()
+	}
+
+	if len(changed)+len(changedServices) < 1 {
+		return nil
+	}
+
+	var state DB
+	if err := dulldb.Select(db, &state); err != nil {
+		return err
+	}
+
+	if state.Inputs == nil {
+		state.Inputs = map[string][][]byte{}
+	}
+
+	if state.Discoveries == nil {
+		state.Discoveries = map[string]HostServices{}
+	}
+
+	for key, ders := range changed {
+		state.Inputs[key] = ders
+	}
+
+	for key, services := range changedServices {
+		state.Discoveries[key] = services
+	}
+
+	log.WithFields(log.Fields{"inputs": len(changed), "discoveries": len(changedServices)}).
+		Debug("remembering inputs' certs and discoveries' services")
+
+	if err := dulldb.Replace(db, &state); err != nil {
+		return err
+	}
+
+	for key, lastUpdate := range updates {
+		remembered[key] = lastUpdate
+	}
+
+	for key, lastUpdate := range servicesUpdates {
+		rememberedServices[key] = lastUpdate
+	}
+
+	return nil
+}
+
+// collectServices returns all discoveries' services and, separately, the ones of AdditiveDiscovery-s.
+// Discoveries which didn't present any services, yet, are handled as their stale policy says.
+func collectServices(
+	from []servicesSet, discoveries []Discovery, lastKnown map[string]HostServices, started time.Time,
+) (services, added HostServices, ok bool) {
+	services = HostServices{}
+	added = HostServices{}
+
+	for i := range from {
+		ss := &from[i]
+		ss.RLock()
+
+		ds := discoveries[i]
+		current := ss.services
+
+		if ss.lastUpdate == (time.Time{}) {
+			stale := ss.options.stale
+			known, hasKnown := lastKnown[ss.options.key]
+
+			switch {
+			case stale.Policy == staleIgnore:
+				ProviderLog(ds).Debug("discovery didn't present any services by now - ignoring it for now")
+				ss.RUnlock()
+				continue
+			case stale.Policy == staleLastKnown && time.Since(started) >= stale.Timeout && hasKnown:
+				current = known
+
+				ProviderLog(ds).WithField("hosts", len(current)).
+					Debug("discovery didn't present any services by now - using last known ones")
+			case stale.Policy == staleLastKnown && time.Since(started) >= stale.Timeout:
+				ss.RUnlock()
+
+				ProviderLog(ds).Warn(
+					"discovery didn't present any services by now and there are no last known ones - not processing, yet",
+				)
+				return nil, nil, false
+			default:
+				ss.RUnlock()
+
+				ProviderLog(ds).Debug("discovery didn't present any services by now - not processing, yet")
+				return nil, nil, false
+			}
+		}
+
+		services := services
+		if _, ok := ds.(AdditiveDiscovery); ok {
+			services = added
+		}
+
+		for host, perHost := range current {
+			all, ok := services[host]
+			if !ok {
+				all = map[string]struct{}{}
+				services[host] = all
+			}
+
+			for svc := range perHost {
+				all[svc] = struct{}{}
+			}
+		}
+
+		ss.RUnlock()
+	}
+
+	return services, added, true
+}
+
+// keepLatest stores cert as certs[key] unless there's a newer one.
+func keepLatest(certs map[sanAndAlgo]*x509.Certificate, key sanAndAlgo, cert *x509.Certificate) {
+	if latest, ok := certs[key]; !ok || cert.NotBefore.After(latest.NotBefore) {
+		certs[key] = cert
+	}
+}
+
+// assemble returns the records of the certs' SANs' services and whether they differ from lastHash.
 func assemble(
-	certs []*x509.Certificate, loggedFailures map[[sha256.Size]byte]struct{},
+	certs map[certGroup][]*x509.Certificate, discovered, added HostServices,
+	loggedFailures map[[sha256.Size]byte]struct{},
 	getBytes func(*x509.Certificate) ([]byte, fuel.ErrorWithStack),
 	hashBytes func([]byte) []byte, cfg *Config, lastHash *[sha512.Size]byte,
 ) (OutputRecordSet, bool) {
 	log.Info("processing new certs set")
 
-	certs = filterCerts(uniqCerts(certs))
-	certsBySan := map[string]*x509.Certificate{}
+	certsBySan := map[sanAndAlgo]*x509.Certificate{}
+
+	for group, certs := range certs {
+		latest := map[sanAndAlgo]*x509.Certificate{}
+
+		for _, cert := range filterCerts(uniqCerts(certs)) {
+			for _, san := range cert.DNSNames {
+				key := sanAndAlgo{san: san, profile: group.profile}
+				if group.byKeyType {
+					// Keep the latest cert per key type, e.g. both RSA and ECDSA ones.
+					key.algo = cert.PublicKeyAlgorithm
+				}
 
-	for _, cert := range certs {
-		for _, san := range cert.DNSNames {
-			if cbs, ok := certsBySan[san]; !ok || cert.NotBefore.After(cbs.NotBefore) {
-				certsBySan[san] = cert
+				keepLatest(latest, key, cert)
 			}
 		}
+
+		// Across groups per key type, so that e.g. an old RSA cert doesn't survive a newer one of another group
+		for key, cert := range latest {
+			key.algo = cert.PublicKeyAlgorithm
+			keepLatest(certsBySan, key, cert)
+		}
 	}
 
 	records := OutputRecordSet{}
-	for san, cert := range certsBySan {
+	for key, cert := range certsBySan {
+		san := key.san
+		record := cfg.Records
+		services := cfg.Services()
+		getBytes, hashBytes := getBytes, hashBytes
+
+		if key.profile != nil {
+			record = key.profile.record
+			getBytes = selectors[record.Selector]
+			hashBytes = matchTypes[record.MatchType]
+
+			if key.profile.services != nil {
+				services = key.profile.services
+			}
+		}
+
 		unhashed, err := getBytes(cert)
 		if err != nil {
 			hash := sha256.Sum256(cert.Raw)
@@ -193,7 +819,52 @@ func assemble(
 		}
 
 		hashed := hashBytes(unhashed)
-		for svc := range cfg.Services() {
+
+		// Hosts not known to any discovery get all configured services.
+		if perHost, ok := discovered[strings.ToLower(san)]; ok {
+			if key.profile == nil || key.profile.services == nil {
+				services = perHost
+			} else {
+				// Only the profile's services which are actually served
+				served := map[string]struct{}{}
+				for svc := range services {
+					if _, ok := perHost[svc]; ok {
+						served[svc] = struct{}{}
+					}
+				}
+
+				services = served
+			}
+		}
+
+		// On top of the above, but also only the profile's ones
+		if extra, ok := added[strings.ToLower(san)]; ok {
+			merged := make(map[string]struct{}, len(services)+len(extra))
+			for svc := range services {
+				merged[svc] = struct{}{}
+			}
+
+			for svc := range extra {
+				if key.profile != nil && key.profile.services != nil {
+					if _, ok := key.profile.services[svc]; !ok {
+						continue
+					}
+				}
+
+				// Already covered by e.g. *._tcp
+				if i := strings.Index(svc, "._"); i >= 0 {
+					if _, ok := services["*"+svc[i:]]; ok {
+						continue
+					}
+				}
+
+				merged[svc] = struct{}{}
+			}
+
+			services = merged
+		}
+
+		for svc := range services {
 			var service string
 			if strings.HasPrefix(san, "*") {
 				service = san
@@ -201,10 +872,14 @@ func assemble(
 				service = fmt.Sprintf("%s.%s", svc, san)
 			}
 
-			records[OutputRecord{cfg.Records, service, Base64er(hashed)}] = struct{}{}
+			records[OutputRecord{Record: record, Service: service, CertSpec: Base64er(hashed)}] = struct{}{}
 		}
 	}
 
+	for rec := range cfg.StaticRecords() {
+		records[rec] = struct{}{}
+	}
+
 	{
 		recordStrings := make([]string, 0, len(records))
 		for rec := range records {
@@ -216,7 +891,7 @@ func assemble(
 		hash := sha512.Sum512([]byte(strings.Join(recordStrings, "\n")))
 		if hash == *lastHash {
 			log.Debug("all quiet on the western front")
-			return nil, false
+			return records, false
 		}
 
 		*lastHash = hash
@@ -262,17 +937,35 @@ func apply(ctx context.Context, to []Output, db string, records OutputRecordSet)
 
 	log.WithField("amount", len(records)).Info("writing records to outputs")
 
-	if err := dulldb.Replace(db, &DB{maybeWritten, nil}); err != nil {
+	// Keep the rest of the state, e.g. the last known certs and services.
+	state.MaybeWritten, state.Written = maybeWritten, nil
+	if err := dulldb.Replace(db, &state); err != nil {
 		return err
 	}
 
+	var mtx sync.Mutex
+	// skippedBy counts the outputs which skipped the records
+	skippedBy := map[OutputRecord]int{}
+
 	g := fuel.NewErrorGroup(ctx, concurrency)
 	for _, out := range to {
 		out := out
 		g.Go(1, func(ctx context.Context) fuel.ErrorWithStack {
-			err := out.Update(ctx, del, create)
-			ProviderLog(out).WithField("amount", len(records)).Info("written records to output")
-			return err
+			skipped, err := out.Update(ctx, del, create)
+			if err != nil {
+				return err
+			}
+
+			ProviderLog(out).WithFields(log.Fields{"written": len(create) - len(skipped), "skipped": len(skipped)}).
+				Info("written records to output")
+
+			mtx.Lock()
+			for record := range skipped {
+				skippedBy[record]++
+			}
+			mtx.Unlock()
+
+			return nil
 		})
 	}
 
@@ -280,5 +973,22 @@ func apply(ctx context.Context, to []Output, db string, records OutputRecordSet)
 		return err
 	}
 
-	return dulldb.Replace(db, &DB{nil, records})
+	// Not to consider them written, but to try them again, e.g. after adding zones
+	written := OutputRecordSet{}
+	var unwritten []OutputRecord
+
+	for record := range records {
+		if skippedBy[record] < len(to) {
+			written[record] = struct{}{}
+		} else {
+			unwritten = append(unwritten, record)
+		}
+	}
+
+	if len(unwritten) > 0 {
+		log.WithField("records", unwritten).Warn("records belong to none of the outputs (e.g. their zones), not writing them")
+	}
+
+	state.MaybeWritten, state.Written = nil, written
+	return dulldb.Replace(db, &state)
 }
@@ -0,0 +1,296 @@
+package bizlogic
+
+import (
+	. "TLSAutomate/internal"
+	. "TLSAutomate/internal/docker"
+	. "TLSAutomate/internal/postfix"
+	. "TLSAutomate/internal/static"
+	"TLSAutomate/internal/test-certs"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"github.com/Al2Klimov/DullDB"
+	"github.com/Al2Klimov/FUeL.go"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAssemble_KeyTypes(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newCert := func(age time.Duration, rsa bool) *x509.Certificate {
+		opts := testcerts.Options{NotBefore: time.Now().Add(-age)}
+		if rsa {
+			opts.Key = rsaKey
+		}
+
+		cert, _ := testcerts.New(t, "example.com", opts)
+		return cert
+	}
+
+	certbot := certGroup{}
+	acmeSh := certGroup{byKeyType: true}
+
+	oldRsa := newCert(90*time.Minute, true)
+	rsa := newCert(60*time.Minute, true)
+	ecdsa := newCert(60*time.Minute, false)
+	newRsa := newCert(30*time.Minute, true)
+
+	for _, tc := range []struct {
+		name     string
+		certs    map[certGroup][]*x509.Certificate
+		expected []*x509.Certificate
+	}{
+		{"latest per SAN", map[certGroup][]*x509.Certificate{certbot: {oldRsa, ecdsa}}, []*x509.Certificate{ecdsa}},
+		{"latest per key type", map[certGroup][]*x509.Certificate{acmeSh: {oldRsa, rsa, ecdsa}}, []*x509.Certificate{rsa, ecdsa}},
+		{
+			"older of another group",
+			map[certGroup][]*x509.Certificate{certbot: {oldRsa}, acmeSh: {rsa, ecdsa}},
+			[]*x509.Certificate{rsa, ecdsa},
+		},
+		{
+			"newer of another group",
+			map[certGroup][]*x509.Certificate{certbot: {newRsa}, acmeSh: {rsa, ecdsa}},
+			[]*x509.Certificate{newRsa, ecdsa},
+		},
+	} {
+		records, _ := assemble(
+			tc.certs, nil, nil, map[[sha256.Size]byte]struct{}{},
+			func(cert *x509.Certificate) ([]byte, fuel.ErrorWithStack) { return cert.Raw, nil },
+			func(b []byte) []byte { return b }, &Config{Ports: Ports{Tcp: []uint16{443}}}, &[sha512.Size]byte{},
+		)
+
+		if len(records) != len(tc.expected) {
+			t.Errorf("%s: expected %d records, got %d", tc.name, len(tc.expected), len(records))
+			continue
+		}
+
+		for _, cert := range tc.expected {
+			record := OutputRecord{Service: "_443._tcp.example.com", CertSpec: Base64er(cert.Raw)}
+			if _, ok := records[record]; !ok {
+				t.Errorf("%s: expected a record for the %s cert valid since %s", tc.name, cert.PublicKeyAlgorithm, cert.NotBefore)
+			}
+		}
+	}
+}
+
+func TestAssemble_Unchanged(t *testing.T) {
+	cert, _ := testcerts.New(t, "Example.com", testcerts.Options{})
+	cfg := &Config{Ports: Ports{Tcp: []uint16{443}}}
+	lastHash := new([sha512.Size]byte)
+	*lastHash = sha512.Sum512(nil)
+
+	for _, tc := range []struct {
+		name     string
+		certs    []*x509.Certificate
+		changed  bool
+		expected int
+		domains  map[string]struct{}
+	}{
+		// The watchlist has to become ready nevertheless.
+		{"initially none", nil, false, 0, map[string]struct{}{}},
+		{"some", []*x509.Certificate{cert}, true, 1, map[string]struct{}{"example.com": {}}},
+		{"the same", []*x509.Certificate{cert}, false, 1, map[string]struct{}{"example.com": {}}},
+	} {
+		records, changed := assemble(
+			map[certGroup][]*x509.Certificate{{}: tc.certs}, nil, nil, map[[sha256.Size]byte]struct{}{},
+			func(cert *x509.Certificate) ([]byte, fuel.ErrorWithStack) { return cert.Raw, nil },
+			func(b []byte) []byte { return b }, cfg, lastHash,
+		)
+
+		if changed != tc.changed || len(records) != tc.expected {
+			t.Errorf("%s: expected %d records, changed=%v, got %d, changed=%v", tc.name, tc.expected, tc.changed, len(records), changed)
+		}
+
+		watchlist := &ctWatchlist{}
+		watchlist.setDomains(records)
+
+		if !watchlist.Ready() || !reflect.DeepEqual(watchlist.domains, tc.domains) {
+			t.Errorf("%s: expected the watchlist to be ready with %v, got %v", tc.name, tc.domains, watchlist.domains)
+		}
+	}
+}
+
+func TestCollect(t *testing.T) {
+	other, _ := testcerts.New(t, "other.example.com", testcerts.Options{})
+	current, _ := testcerts.New(t, "current.example.com", testcerts.Options{})
+	known, _ := testcerts.New(t, "known.example.com", testcerts.Options{})
+
+	longAgo := time.Now().Add(-time.Hour)
+	block, ignore := Stale{Policy: staleBlock}, Stale{Policy: staleIgnore}
+	lastKnownPolicy := Stale{Policy: staleLastKnown, Timeout: time.Minute}
+	lastKnown := map[string][]*x509.Certificate{"known": {known}, "known empty": {}}
+
+	for _, tc := range []struct {
+		name    string
+		stale   Stale
+		key     string
+		started time.Time
+		// presented are the stale input's certs if not nil.
+		presented []*x509.Certificate
+		expected  []*x509.Certificate
+	}{
+		{"presented", block, "known", longAgo, []*x509.Certificate{current}, []*x509.Certificate{other, current}},
+		{"presented nothing", block, "known", longAgo, []*x509.Certificate{}, []*x509.Certificate{other}},
+		{"block", block, "known", longAgo, nil, nil},
+		{"ignore", ignore, "known", longAgo, nil, []*x509.Certificate{other}},
+		{"last known", lastKnownPolicy, "known", longAgo, nil, []*x509.Certificate{other, known}},
+		{"last known empty", lastKnownPolicy, "known empty", longAgo, nil, []*x509.Certificate{other}},
+		{"last known before timeout", lastKnownPolicy, "known", time.Now(), nil, nil},
+		{"no last known", lastKnownPolicy, "unknown", longAgo, nil, nil},
+	} {
+		from := []certsSet{
+			{certs: []*x509.Certificate{other}, lastUpdate: longAgo, options: inputOptions{key: "other"}},
+			{certs: tc.presented, options: inputOptions{key: tc.key, stale: tc.stale}},
+		}
+
+		if tc.presented != nil {
+			from[1].lastUpdate = longAgo
+		}
+
+		inputs := []Input{&Static{Numbered: Numbered{Nr: 1}}, &Static{Numbered: Numbered{Nr: 2}}}
+
+		certs, ok := collect(from, inputs, lastKnown, tc.started)
+		if ok != (tc.expected != nil) {
+			t.Errorf("%s: expected ok=%v, got %v", tc.name, tc.expected != nil, ok)
+			continue
+		}
+
+		if ok && !reflect.DeepEqual(certs, map[certGroup][]*x509.Certificate{{}: tc.expected}) {
+			t.Errorf("%s: expected %d certs, got %v", tc.name, len(tc.expected), certs)
+		}
+	}
+}
+
+func TestCollectServices(t *testing.T) {
+	other := HostServices{"other.example.com": {"_443._tcp": {}}}
+	current := HostServices{"current.example.com": {"_25._tcp": {}}}
+	known := HostServices{"known.example.com": {"_465._tcp": {}}}
+
+	longAgo := time.Now().Add(-time.Hour)
+	block, ignore := Stale{Policy: staleBlock}, Stale{Policy: staleIgnore}
+	lastKnownPolicy := Stale{Policy: staleLastKnown, Timeout: time.Minute}
+	lastKnown := map[string]HostServices{"known": known}
+
+	for _, tc := range []struct {
+		name    string
+		stale   Stale
+		key     string
+		started time.Time
+		// presented are the stale discovery's services if not nil.
+		presented HostServices
+		// additive is whether the stale discovery is an AdditiveDiscovery.
+		additive bool
+		ok       bool
+		services HostServices
+		added    HostServices
+	}{
+		{
+			"presented", block, "known", longAgo, current, false, true,
+			HostServices{"other.example.com": {"_443._tcp": {}}, "current.example.com": {"_25._tcp": {}}}, HostServices{},
+		},
+		{"presented additively", block, "known", longAgo, current, true, true, other, current},
+		{"block", block, "known", longAgo, nil, false, false, nil, nil},
+		{"ignore", ignore, "known", longAgo, nil, false, true, other, HostServices{}},
+		{
+			"last known", lastKnownPolicy, "known", longAgo, nil, false, true,
+			HostServices{"other.example.com": {"_443._tcp": {}}, "known.example.com": {"_465._tcp": {}}}, HostServices{},
+		},
+		{"last known additively", lastKnownPolicy, "known", longAgo, nil, true, true, other, known},
+		{"last known before timeout", lastKnownPolicy, "known", time.Now(), nil, false, false, nil, nil},
+		{"no last known", lastKnownPolicy, "unknown", longAgo, nil, false, false, nil, nil},
+	} {
+		from := []servicesSet{
+			{services: other, lastUpdate: longAgo, options: discoveryOptions{key: "other"}},
+			{services: tc.presented, options: discoveryOptions{key: tc.key, stale: tc.stale}},
+		}
+
+		if tc.presented != nil {
+			from[1].lastUpdate = longAgo
+		}
+
+		discoveries := []Discovery{&Docker{Numbered: Numbered{Nr: 1}}, &Docker{Numbered: Numbered{Nr: 2}}}
+		if tc.additive {
+			discoveries[1] = &Postfix{Numbered: Numbered{Nr: 1}}
+		}
+
+		services, added, ok := collectServices(from, discoveries, lastKnown, tc.started)
+		if ok != tc.ok {
+			t.Errorf("%s: expected ok=%v, got %v", tc.name, tc.ok, ok)
+			continue
+		}
+
+		if !reflect.DeepEqual(services, tc.services) || !reflect.DeepEqual(added, tc.added) {
+			t.Errorf("%s: expected %v and %v, got %v and %v", tc.name, tc.services, tc.added, services, added)
+		}
+	}
+}
+
+func TestLoadLastKnown(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "db.json")
+
+	lastKnown, lastKnownServices, err := loadLastKnown(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lastKnown) != 0 || lastKnownServices == nil || len(lastKnownServices) != 0 {
+		t.Errorf("expected nothing from a missing DB, got %v and %v", lastKnown, lastKnownServices)
+	}
+
+	cert, _ := testcerts.New(t, "example.com", testcerts.Options{})
+	services := HostServices{"example.com": {"_443._tcp": {}}}
+	asOf := time.Now()
+	lastKnownPolicy := Stale{Policy: staleLastKnown}
+
+	from := []certsSet{
+		{certs: []*x509.Certificate{cert}, lastUpdate: asOf, options: inputOptions{key: "certs", stale: lastKnownPolicy}},
+		{certs: []*x509.Certificate{}, lastUpdate: asOf, options: inputOptions{key: "none", stale: lastKnownPolicy}},
+		{certs: []*x509.Certificate{cert}, options: inputOptions{key: "stale", stale: lastKnownPolicy}},
+		{certs: []*x509.Certificate{cert}, lastUpdate: asOf, options: inputOptions{key: "block", stale: Stale{Policy: staleBlock}}},
+	}
+
+	discovered := []servicesSet{
+		{services: services, lastUpdate: asOf, options: discoveryOptions{key: "services", stale: lastKnownPolicy}},
+		{services: services, lastUpdate: asOf, options: discoveryOptions{key: "ignore", stale: Stale{Policy: staleIgnore}}},
+	}
+
+	if err := remember(db, from, discovered, map[string]time.Time{}, map[string]time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	lastKnown, lastKnownServices, err = loadLastKnown(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]*x509.Certificate{"certs": {cert}, "none": {}}
+	if !reflect.DeepEqual(lastKnown, expected) {
+		t.Errorf("expected %v, got %v", expected, lastKnown)
+	}
+
+	if expected := map[string]HostServices{"services": services}; !reflect.DeepEqual(lastKnownServices, expected) {
+		t.Errorf("expected %v, got %v", expected, lastKnownServices)
+	}
+
+	if err := dulldb.Replace(db, &DB{Inputs: map[string][][]byte{"broken": {[]byte("not DER"), cert.Raw}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	lastKnown, _, err = loadLastKnown(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Skipping the broken cert only
+	if expected := map[string][]*x509.Certificate{"broken": {cert}}; !reflect.DeepEqual(lastKnown, expected) {
+		t.Errorf("expected %v, got %v", expected, lastKnown)
+	}
+}
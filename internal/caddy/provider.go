@@ -0,0 +1,114 @@
+package caddy
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"crypto/x509"
+	"github.com/Al2Klimov/FUeL.go"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+type Caddy struct {
+	Numbered
+
+	DataDir string
+	// Issuers limits the directories under certificates/ to read, e.g. "acme.zerossl.com-v2-dv90".
+	// All are read if empty.
+	Issuers []string
+}
+
+var _ Input = (*Caddy)(nil)
+
+func (*Caddy) Kind() string {
+	return "Caddy"
+}
+
+func (c *Caddy) Ping(context.Context) fuel.ErrorWithStack {
+	_, err := ioutil.ReadDir(c.DataDir)
+	return fuel.AttachStackToError(err, 0)
+}
+
+func (c *Caddy) Poll(ctx context.Context, since time.Time) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
+	return PollFiles(ctx, c, since, c.dirs, c.read)
+}
+
+func (c *Caddy) read(since time.Time) ([]*x509.Certificate, bool, time.Time, fuel.ErrorWithStack) {
+	if _, err := os.Stat(c.certsDir()); err != nil {
+		if os.IsNotExist(err) {
+			ProviderLog(c).Warn("certificates directory doesn't exist, assuming only temporarily")
+			return nil, false, since, nil
+		}
+
+		return nil, false, time.Time{}, fuel.AttachStackToError(err, 0)
+	}
+
+	issuerDirs, certFiles, err := c.files()
+	if err != nil {
+		return nil, false, time.Time{}, err
+	}
+
+	return ReadLeaves(c, since, append([]string{c.certsDir()}, issuerDirs...), certFiles)
+}
+
+// dirs returns certificates/ itself, the issuers' directories in it and the domains' ones in the latter.
+func (c *Caddy) dirs() []string {
+	issuerDirs, certFiles, err := c.files()
+	if err != nil {
+		ProviderLog(c).WithError(err).Warn("can't list certificates")
+	}
+
+	return append(append([]string{c.certsDir()}, issuerDirs...), DirsOf(certFiles...)...)
+}
+
+// files returns certificates/<issuer>/ and certificates/<issuer>/<domain>/<domain>.crt.
+func (c *Caddy) files() (issuerDirs []string, certFiles []string, err fuel.ErrorWithStack) {
+	if len(c.Issuers) > 0 {
+		for _, issuer := range c.Issuers {
+			issuerDirs = append(issuerDirs, path.Join(c.certsDir(), issuer))
+		}
+	} else {
+		entries, err := readDir(c.certsDir())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				issuerDirs = append(issuerDirs, path.Join(c.certsDir(), entry.Name()))
+			}
+		}
+	}
+
+	for _, issuerDir := range issuerDirs {
+		entries, err := readDir(issuerDir)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() {
+				domain := entry.Name()
+				certFiles = append(certFiles, path.Join(issuerDir, domain, domain+".crt"))
+			}
+		}
+	}
+
+	return
+}
+
+func (c *Caddy) certsDir() string {
+	return path.Join(c.DataDir, "certificates")
+}
+
+// readDir is like ioutil.ReadDir, but treats a missing directory as an empty one.
+func readDir(dir string) ([]os.FileInfo, fuel.ErrorWithStack) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fuel.AttachStackToError(err, 0)
+	}
+
+	return entries, nil
+}
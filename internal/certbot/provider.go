@@ -0,0 +1,86 @@
+package certbot
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"crypto/x509"
+	"github.com/Al2Klimov/FUeL.go"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+type Certbot struct {
+	Numbered
+
+	ConfigDir string
+}
+
+var _ Input = (*Certbot)(nil)
+
+func (*Certbot) Kind() string {
+	return "certbot"
+}
+
+func (c *Certbot) Ping(context.Context) fuel.ErrorWithStack {
+	_, err := ioutil.ReadDir(c.ConfigDir)
+	return fuel.AttachStackToError(err, 0)
+}
+
+func (c *Certbot) Poll(ctx context.Context, since time.Time) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
+	return PollFiles(ctx, c, since, c.dirs, c.read)
+}
+
+func (c *Certbot) read(since time.Time) ([]*x509.Certificate, bool, time.Time, fuel.ErrorWithStack) {
+	if _, err := os.Stat(c.liveDir()); err != nil {
+		if os.IsNotExist(err) {
+			ProviderLog(c).Warn("live directory doesn't exist, assuming only temporarily")
+			return nil, false, since, nil
+		}
+
+		return nil, false, time.Time{}, fuel.AttachStackToError(err, 0)
+	}
+
+	certFiles, err := c.certFiles()
+	if err != nil {
+		return nil, false, time.Time{}, err
+	}
+
+	return ReadLeaves(c, since, []string{c.liveDir()}, certFiles)
+}
+
+// dirs returns live/ itself, all lineages' directories in it and the ones in archive/ their certificates point to.
+func (c *Certbot) dirs() []string {
+	certFiles, err := c.certFiles()
+	if err != nil {
+		ProviderLog(c).WithError(err).Warn("can't list lineages")
+	}
+
+	return append([]string{c.liveDir()}, DirsOf(certFiles...)...)
+}
+
+// certFiles returns live/*/cert.pem.
+func (c *Certbot) certFiles() ([]string, fuel.ErrorWithStack) {
+	entries, err := ioutil.ReadDir(c.liveDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, fuel.AttachStackToError(err, 0)
+	}
+
+	var certFiles []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			certFiles = append(certFiles, path.Join(c.liveDir(), entry.Name(), "cert.pem"))
+		}
+	}
+
+	return certFiles, nil
+}
+
+func (c *Certbot) liveDir() string {
+	return path.Join(c.ConfigDir, "live")
+}
@@ -0,0 +1,20 @@
+//go:build linux
+// +build linux
+
+package internal
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// changeTime returns the inode change time of st which, unlike the mod time,
+// also changes on renames and e.g. "cp -p".
+func changeTime(st os.FileInfo) time.Time {
+	if sys, ok := st.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(int64(sys.Ctim.Sec), int64(sys.Ctim.Nsec))
+	}
+
+	return time.Time{}
+}
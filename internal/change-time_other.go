@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package internal
+
+import (
+	"os"
+	"time"
+)
+
+// changeTime isn't implemented on this platform.
+func changeTime(os.FileInfo) time.Time {
+	return time.Time{}
+}
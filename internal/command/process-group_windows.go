@@ -0,0 +1,12 @@
+package command
+
+import "os/exec"
+
+// setProcessGroup isn't implemented on this platform.
+func setProcessGroup(*exec.Cmd) {
+}
+
+// killProcessGroup only kills cmd itself on this platform.
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
@@ -0,0 +1,160 @@
+package command
+
+import (
+	. "TLSAutomate/internal"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"github.com/Al2Klimov/FUeL.go"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type Command struct {
+	Numbered
+
+	// Argv is the command to run and its arguments. Its stdout shall be PEM.
+	Argv     []string
+	Interval time.Duration
+	Timeout  time.Duration
+
+	once      sync.Once
+	sighup    chan os.Signal
+	lastHash  [sha256.Size]byte
+	lastCerts []*x509.Certificate
+	succeeded bool
+}
+
+// killGrace is how long to wait for the command's output to be closed after killing it.
+var killGrace = 10 * time.Second
+
+// errNoSuccess signals that the command hasn't succeeded, yet.
+var errNoSuccess = errors.New("command hasn't succeeded, yet")
+
+var _ Input = (*Command)(nil)
+
+func (*Command) Kind() string {
+	return "command"
+}
+
+// Ping checks whether the command exists.
+func (c *Command) Ping(context.Context) fuel.ErrorWithStack {
+	_, err := exec.LookPath(c.Argv[0])
+	return fuel.AttachStackToError(err, 0)
+}
+
+// Poll runs the command every Interval (or on SIGHUP) and returns as soon as the printed leaves change.
+// Nothing is returned until the command has succeeded once.
+func (c *Command) Poll(ctx context.Context, since time.Time) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
+	c.once.Do(func() {
+		c.sighup = make(chan os.Signal, 1)
+		signal.Notify(c.sighup, syscall.SIGHUP)
+	})
+
+	for {
+		certs, asOf, err := PollPeriodically(ctx, c, since, c.Interval, &c.lastHash, c.sighup, c.read)
+		if err != nil && errors.Is(err, errNoSuccess) {
+			ProviderLog(c).Debug("command hasn't succeeded, yet - not reporting")
+			since = time.Now()
+			continue
+		}
+
+		return certs, asOf, err
+	}
+}
+
+func (c *Command) read(ctx context.Context) ([]*x509.Certificate, fuel.ErrorWithStack) {
+	runCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command(c.Argv[0], c.Argv[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	setProcessGroup(cmd)
+
+	start := time.Now()
+	errRn := cmd.Start()
+
+	if errRn == nil {
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+
+		select {
+		case errRn = <-done:
+		case <-runCtx.Done():
+			// Not only the command itself, but also e.g. its children holding stdout
+			killProcessGroup(cmd)
+			errRn = runCtx.Err()
+
+			timer := time.NewTimer(killGrace)
+
+			select {
+			case <-done:
+				timer.Stop()
+			case <-timer.C:
+				// E.g. a grandchild in its own session. Its output is still being copied, so don't touch it.
+				stderr = &bytes.Buffer{}
+				ProviderLog(c).WithField("command", c.Argv).Warn("command's output still open after killing it, abandoning it")
+			}
+		}
+	}
+
+	logger := ProviderLog(c).WithField("command", c.Argv)
+	for lines := bufio.NewScanner(stderr); lines.Scan(); {
+		if line := lines.Text(); line != "" {
+			logger.WithField("line", line).Info("command wrote to stderr")
+		}
+	}
+
+	if errRn != nil {
+		if ctx.Err() != nil {
+			return nil, fuel.AttachStackToError(ctx.Err(), 0)
+		}
+
+		// Not to delete the records just because of a failing script
+		logger = logger.WithField("last_known", len(c.lastCerts))
+
+		var errEx *exec.ExitError
+		switch {
+		case runCtx.Err() != nil:
+			logger.WithField("timeout", c.Timeout).Warn("command timed out")
+		case errors.As(errRn, &errEx):
+			logger.WithField("exit_code", errEx.ExitCode()).Warn("command failed")
+		default:
+			logger.WithError(errRn).Warn("can't run command")
+		}
+
+		return c.lastResort()
+	}
+
+	leaves, errPL := ParseLeaves(stdout.Bytes())
+	if errPL != nil {
+		logger.WithError(errPL).WithField("last_known", len(c.lastCerts)).Warn("can't parse PEM")
+		return c.lastResort()
+	}
+
+	logger.WithFields(log.Fields{"took": time.Since(start), "leaves": len(leaves)}).Trace("ran command")
+
+	c.lastCerts = leaves
+	c.succeeded = true
+	return leaves, nil
+}
+
+// lastResort returns the last known certs, if any.
+func (c *Command) lastResort() ([]*x509.Certificate, fuel.ErrorWithStack) {
+	if !c.succeeded {
+		return nil, fuel.AttachStackToError(errNoSuccess, 0)
+	}
+
+	return c.lastCerts, nil
+}
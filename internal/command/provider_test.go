@@ -0,0 +1,38 @@
+//go:build !windows
+// +build !windows
+
+package command
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCommand_read_Timeout(t *testing.T) {
+	killGrace = 100 * time.Millisecond
+
+	for _, tc := range []struct {
+		name string
+		argv []string
+	}{
+		{"child", []string{"sh", "-c", "sleep 60 & sleep 60"}},
+		// Not killed with the process group, holding stdout
+		{"grandchild in its own session", []string{"sh", "-c", "setsid sleep 5 & sleep 60"}},
+	} {
+		c := &Command{Numbered: Numbered{Nr: 1}, Argv: tc.argv, Timeout: 100 * time.Millisecond}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_, err := c.read(ctx)
+		ctxErr := ctx.Err()
+		cancel()
+
+		if ctxErr != nil {
+			t.Errorf("%s: expected the command to time out", tc.name)
+		} else if !errors.Is(err, errNoSuccess) {
+			t.Errorf("%s: expected %v, got %v", tc.name, errNoSuccess, err)
+		}
+	}
+}
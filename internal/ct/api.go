@@ -0,0 +1,137 @@
+package ct
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Al2Klimov/FUeL.go"
+	"github.com/hashicorp/go-cleanhttp"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// signedTreeHead is the response of get-sth (RFC 6962, 4.3).
+type signedTreeHead struct {
+	TreeSize  uint64 `json:"tree_size"`
+	Timestamp uint64 `json:"timestamp"`
+}
+
+// entry is one of get-entries' ones (RFC 6962, 4.6).
+type entry struct {
+	LeafInput []byte `json:"leaf_input"`
+	ExtraData []byte `json:"extra_data"`
+}
+
+type httpStatus uint16
+
+var _ error = httpStatus(0)
+
+func (hs httpStatus) Error() string {
+	return fmt.Sprintf("HTTP status: %d", int(hs))
+}
+
+var errShortLeaf = errors.New("leaf too short")
+
+// sth returns the current tree head.
+func (l *CtLog) sth(ctx context.Context) (*signedTreeHead, fuel.ErrorWithStack) {
+	sth := &signedTreeHead{}
+	return sth, l.get(ctx, "ct/v1/get-sth", nil, sth)
+}
+
+// entries returns the entries from start to end (both inclusive), maybe less of them.
+func (l *CtLog) entries(ctx context.Context, start, end uint64) ([]entry, fuel.ErrorWithStack) {
+	var resp struct {
+		Entries []entry `json:"entries"`
+	}
+
+	query := url.Values{
+		"start": []string{strconv.FormatUint(start, 10)},
+		"end":   []string{strconv.FormatUint(end, 10)},
+	}
+
+	return resp.Entries, l.get(ctx, "ct/v1/get-entries", query, &resp)
+}
+
+func (l *CtLog) get(ctx context.Context, path string, query url.Values, resp interface{}) fuel.ErrorWithStack {
+	l.once.Do(func() { l.client = cleanhttp.DefaultPooledClient() })
+
+	base, err := url.Parse(strings.TrimSuffix(l.Log, "/") + "/")
+	if err != nil {
+		return fuel.AttachStackToError(err, 0)
+	}
+
+	uri := base.ResolveReference(&url.URL{Path: path, RawQuery: query.Encode()})
+
+	req, err := http.NewRequestWithContext(ctx, "GET", uri.String(), nil)
+	if err != nil {
+		return fuel.AttachStackToError(err, 0)
+	}
+
+	response, err := l.client.Do(req)
+	logger := ProviderLog(l).WithField("url", uri.Redacted())
+
+	if err != nil {
+		logger.WithError(err).Debug("performed HTTP request")
+		return fuel.AttachStackToError(err, 0)
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	logger.WithField("status", response.StatusCode).Debug("performed HTTP request")
+
+	if response.StatusCode > 299 {
+		return fuel.AttachStackToError(httpStatus(response.StatusCode), 0)
+	}
+
+	return fuel.AttachStackToError(json.NewDecoder(response.Body).Decode(resp), 0)
+}
+
+// parseEntry returns an entry's certificate or, for precertificate entries, the precertificate.
+func parseEntry(e entry) (*x509.Certificate, error) {
+	// MerkleTreeLeaf: version, leaf_type, TimestampedEntry: timestamp, entry_type, ...
+	if len(e.LeafInput) < 12 {
+		return nil, errShortLeaf
+	}
+
+	if version, leafType := e.LeafInput[0], e.LeafInput[1]; version != 0 || leafType != 0 {
+		return nil, fmt.Errorf("unsupported leaf version %d or type %d", version, leafType)
+	}
+
+	var der []byte
+	var err error
+
+	switch entryType := binary.BigEndian.Uint16(e.LeafInput[10:12]); entryType {
+	case 0: // x509_entry: ASN.1Cert
+		der, err = readAsn1Cert(e.LeafInput[12:])
+	case 1: // precert_entry: only the TBSCertificate, but extra_data starts with the whole precertificate
+		der, err = readAsn1Cert(e.ExtraData)
+	default:
+		return nil, fmt.Errorf("unsupported entry type %d", entryType)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The precertificate's critical poison extension doesn't hurt here.
+	return x509.ParseCertificate(der)
+}
+
+// readAsn1Cert reads an ASN.1Cert, i.e. a DER certificate with a 24-bit length prefix.
+func readAsn1Cert(b []byte) ([]byte, error) {
+	if len(b) < 3 {
+		return nil, errShortLeaf
+	}
+
+	length := int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+	if len(b)-3 < length {
+		return nil, errShortLeaf
+	}
+
+	return b[3 : 3+length], nil
+}
@@ -0,0 +1,164 @@
+package ct
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"github.com/Al2Klimov/FUeL.go"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// batchSize is how many entries to request at once. Logs may return less.
+const batchSize = 256
+
+// Watchlist tells which certificates to warn about.
+type Watchlist interface {
+	// Ready tells whether Unknown can judge, yet.
+	Ready() bool
+	// Unknown returns the SANs of cert which are ours unless we know cert (or at least its key).
+	Unknown(cert *x509.Certificate) []string
+}
+
+// CtLog monitors a Certificate Transparency log (RFC 6962) for certificates issued for our domains.
+type CtLog struct {
+	Numbered
+
+	// Log is the base URL, e.g. https://ct.googleapis.com/logs/us1/argon2025h2/.
+	Log      string
+	Interval time.Duration
+	// Grace is how long a cert may stay unknown before warning about it.
+	// Especially precerts are logged before our inputs see the issued certs.
+	Grace time.Duration
+
+	once   sync.Once
+	client *http.Client
+	// suspects are the unknown certs by index, not warned about, yet.
+	suspects map[uint64]suspect
+}
+
+// suspect is a cert which was unknown at the given time.
+type suspect struct {
+	cert *x509.Certificate
+	sans []string
+	seen time.Time
+}
+
+var _ Provider = (*CtLog)(nil)
+
+func (*CtLog) Kind() string {
+	return "CT log"
+}
+
+func (l *CtLog) Ping(ctx context.Context) fuel.ErrorWithStack {
+	_, err := l.sth(ctx)
+	return err
+}
+
+// Monitor checks every Interval all certificates logged since Monitor started
+// and warns about the ones the watchlist considers unknown for at least Grace.
+func (l *CtLog) Monitor(ctx context.Context, watchlist Watchlist) fuel.ErrorWithStack {
+	sth, err := l.sth(ctx)
+	if err != nil {
+		return err
+	}
+
+	next := sth.TreeSize
+	ProviderLog(l).WithField("tree_size", next).Info("monitoring CT log")
+
+	for {
+		timer := time.NewTimer(l.Interval)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fuel.AttachStackToError(ctx.Err(), 0)
+		case <-timer.C:
+		}
+
+		if !watchlist.Ready() {
+			ProviderLog(l).Debug("our certs and domains aren't known, yet - not checking log entries")
+			continue
+		}
+
+		l.recheck(watchlist)
+
+		sth, err := l.sth(ctx)
+		if err != nil {
+			// Not worth stopping everything else
+			ProviderLog(l).WithError(err).Warn("can't get tree head, retrying later")
+			continue
+		}
+
+		for next < sth.TreeSize {
+			end := next + batchSize - 1
+			if end >= sth.TreeSize {
+				end = sth.TreeSize - 1
+			}
+
+			entries, err := l.entries(ctx, next, end)
+			if err != nil {
+				ProviderLog(l).WithError(err).Warn("can't get log entries, retrying later")
+				break
+			}
+
+			if len(entries) < 1 {
+				break
+			}
+
+			for i, e := range entries {
+				l.check(next+uint64(i), e, watchlist)
+			}
+
+			next += uint64(len(entries))
+		}
+	}
+}
+
+func (l *CtLog) check(index uint64, e entry, watchlist Watchlist) {
+	cert, err := parseEntry(e)
+	if err != nil {
+		ProviderLog(l).WithError(err).WithField("index", index).Debug("can't parse log entry")
+		return
+	}
+
+	if sans := watchlist.Unknown(cert); len(sans) > 0 {
+		if l.suspects == nil {
+			l.suspects = map[uint64]suspect{}
+		}
+
+		l.suspects[index] = suspect{cert, sans, time.Now()}
+		l.logCert(index, cert, sans).Debug("cert for our domains unknown to all inputs, checking again later")
+	}
+}
+
+// recheck warns about the suspects unknown for at least Grace and forgets them.
+func (l *CtLog) recheck(watchlist Watchlist) {
+	for index, s := range l.suspects {
+		if time.Since(s.seen) < l.Grace {
+			continue
+		}
+
+		if sans := watchlist.Unknown(s.cert); len(sans) > 0 {
+			l.logCert(index, s.cert, sans).Warn("cert for our domains unknown to all inputs")
+		} else {
+			l.logCert(index, s.cert, s.sans).Debug("cert for our domains became known")
+		}
+
+		delete(l.suspects, index)
+	}
+}
+
+func (l *CtLog) logCert(index uint64, cert *x509.Certificate, sans []string) *log.Entry {
+	return ProviderLog(l).WithFields(log.Fields{
+		"index":     index,
+		"sans":      sans,
+		"subject":   cert.Subject.String(),
+		"issuer":    cert.Issuer.String(),
+		"serial":    hex.EncodeToString(cert.SerialNumber.Bytes()),
+		"not_after": cert.NotAfter,
+	})
+}
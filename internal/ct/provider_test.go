@@ -0,0 +1,258 @@
+package ct
+
+import (
+	. "TLSAutomate/internal"
+	"TLSAutomate/internal/test-certs"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// standIn serves entries via get-sth and get-entries. It signals every get-sth via sths.
+type standIn struct {
+	sync.Mutex
+
+	entries []entry
+	sths    chan struct{}
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	switch r.URL.Path {
+	case "/log/ct/v1/get-sth":
+		_ = json.NewEncoder(w).Encode(signedTreeHead{TreeSize: uint64(len(s.entries))})
+
+		select {
+		case s.sths <- struct{}{}:
+		default:
+		}
+	case "/log/ct/v1/get-entries":
+		start, errSt := strconv.ParseUint(r.URL.Query().Get("start"), 10, 64)
+		end, errEn := strconv.ParseUint(r.URL.Query().Get("end"), 10, 64)
+		if errSt != nil || errEn != nil || start > end || end >= uint64(len(s.entries)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string][]entry{"entries": s.entries[start : end+1]})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *standIn) log(entries ...entry) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.entries = append(s.entries, entries...)
+}
+
+// watchlist considers all SANs ours and signals every check of a cert via checked.
+type watchlist struct {
+	sync.Mutex
+
+	keys    map[[sha256.Size]byte]struct{}
+	checked chan string
+}
+
+func (w *watchlist) Ready() bool {
+	return true
+}
+
+func (w *watchlist) Unknown(cert *x509.Certificate) []string {
+	w.Lock()
+	_, ok := w.keys[sha256.Sum256(cert.RawSubjectPublicKeyInfo)]
+	w.Unlock()
+
+	select {
+	case w.checked <- cert.DNSNames[0]:
+	default:
+	}
+
+	if ok {
+		return nil
+	}
+
+	return cert.DNSNames
+}
+
+func (w *watchlist) know(cert *x509.Certificate) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.keys[sha256.Sum256(cert.RawSubjectPublicKeyInfo)] = struct{}{}
+}
+
+const unknownCert = "cert for our domains unknown to all inputs"
+
+func TestParseEntry(t *testing.T) {
+	cert := newCert(t, "a.example.com", false)
+	precert := newCert(t, "b.example.com", true)
+
+	for _, tc := range []struct {
+		name string
+		e    entry
+		san  string
+	}{
+		{"x509_entry", x509Entry(cert), "a.example.com"},
+		{"precert_entry", precertEntry(precert), "b.example.com"},
+	} {
+		parsed, err := parseEntry(tc.e)
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err.Error())
+		} else if len(parsed.DNSNames) != 1 || parsed.DNSNames[0] != tc.san {
+			t.Errorf("%s: expected %s, got %v", tc.name, tc.san, parsed.DNSNames)
+		}
+	}
+
+	broken := x509Entry(cert)
+	broken.LeafInput = broken.LeafInput[:20]
+
+	if _, err := parseEntry(broken); err == nil {
+		t.Error("expected an error for a truncated entry")
+	}
+}
+
+func TestCtLog_Monitor(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	ctLog := &standIn{sths: make(chan struct{})}
+	srv := httptest.NewServer(ctLog)
+	defer srv.Close()
+
+	l := &CtLog{
+		Numbered: Numbered{Nr: 1}, Log: srv.URL + "/log", Interval: 10 * time.Millisecond, Grace: 200 * time.Millisecond,
+	}
+	wl := &watchlist{keys: map[[sha256.Size]byte]struct{}{}, checked: make(chan string, 8)}
+
+	// Logged before monitoring, so ignored
+	ctLog.log(x509Entry(newCert(t, "old.example.com", false)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- l.Monitor(ctx, wl) }()
+
+	// Someone else's cert and a precert of our renewal
+	foreign := newCert(t, "a.example.com", false)
+	renewal := newCert(t, "b.example.com", true)
+
+	<-ctLog.sths
+	ctLog.log(x509Entry(foreign), precertEntry(renewal))
+
+	for checked := map[string]bool{}; !checked["b.example.com"]; {
+		select {
+		case <-ctx.Done():
+			t.Fatal("expected b.example.com to be checked")
+		case san := <-wl.checked:
+			checked[san] = true
+		}
+	}
+
+	// Our inputs see the issued cert a few intervals later
+	time.Sleep(50 * time.Millisecond)
+	wl.know(renewal)
+
+	for warned := false; !warned; {
+		select {
+		case <-ctx.Done():
+			t.Fatal("expected a warning about a.example.com")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		for _, e := range hook.AllEntries() {
+			if e.Level == log.WarnLevel && e.Message == unknownCert {
+				warned = true
+			}
+		}
+	}
+
+	// Give b.example.com's suspect a chance to be (wrongly) warned about
+	time.Sleep(100 * time.Millisecond)
+
+	cancel()
+	<-done
+
+	var warnings []interface{}
+	for _, e := range hook.AllEntries() {
+		if e.Level == log.WarnLevel && e.Message == unknownCert {
+			warnings = append(warnings, e.Data["sans"])
+		}
+	}
+
+	if len(warnings) != 1 {
+		t.Fatalf("expected 1 warning, got %v", warnings)
+	}
+
+	if sans, ok := warnings[0].([]string); !ok || len(sans) != 1 || sans[0] != "a.example.com" {
+		t.Errorf("expected a warning about a.example.com, got %v", warnings[0])
+	}
+}
+
+// x509Entry returns a get-entries entry of cert.
+func x509Entry(cert *x509.Certificate) entry {
+	// MerkleTreeLeaf: version, leaf_type, TimestampedEntry: timestamp, entry_type, ASN.1Cert, extensions
+	leaf := appendTimestamp([]byte{0, 0})
+	leaf = append(leaf, 0, 0)
+	leaf = appendAsn1Cert(leaf, cert.Raw)
+	leaf = append(leaf, 0, 0)
+
+	return entry{LeafInput: leaf, ExtraData: appendAsn1Cert(nil, nil)}
+}
+
+// precertEntry returns a get-entries entry of precert.
+func precertEntry(precert *x509.Certificate) entry {
+	// MerkleTreeLeaf: version, leaf_type, TimestampedEntry: timestamp, entry_type, PreCert, extensions
+	leaf := appendTimestamp([]byte{0, 0})
+	leaf = append(leaf, 0, 1)
+	leaf = append(leaf, make([]byte, sha256.Size)...)
+	leaf = appendAsn1Cert(leaf, precert.RawTBSCertificate)
+	leaf = append(leaf, 0, 0)
+
+	// PrecertChainEntry: pre_certificate, precertificate_chain
+	return entry{LeafInput: leaf, ExtraData: appendAsn1Cert(appendAsn1Cert(nil, precert.Raw), nil)}
+}
+
+func appendTimestamp(b []byte) []byte {
+	var timestamp [8]byte
+	binary.BigEndian.PutUint64(timestamp[:], uint64(time.Now().UnixNano()/int64(time.Millisecond)))
+
+	return append(b, timestamp[:]...)
+}
+
+// appendAsn1Cert appends der with a 24-bit length prefix, also suitable for empty vectors of such.
+func appendAsn1Cert(b, der []byte) []byte {
+	b = append(b, byte(len(der)>>16), byte(len(der)>>8), byte(len(der)))
+	return append(b, der...)
+}
+
+func newCert(t *testing.T, san string, precert bool) *x509.Certificate {
+	t.Helper()
+
+	var opts testcerts.Options
+	if precert {
+		// RFC 6962, 3.1.
+		opts.Extensions = []pkix.Extension{{
+			Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}, Critical: true, Value: asn1.NullBytes,
+		}}
+	}
+
+	cert, _ := testcerts.New(t, san, opts)
+	return cert
+}
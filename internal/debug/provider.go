@@ -22,11 +22,11 @@ func (Debug) Ping(context.Context) fuel.ErrorWithStack {
 	return nil
 }
 
-func (d Debug) Update(_ context.Context, del, create OutputRecordSet) fuel.ErrorWithStack {
+func (d Debug) Update(_ context.Context, del, create OutputRecordSet) (OutputRecordSet, fuel.ErrorWithStack) {
 	logger := ProviderLog(d)
 
 	logger.WithField("records", del).Debug("would delete records")
 	logger.WithField("records", create).Debug("would create records")
 
-	return nil
+	return nil, nil
 }
@@ -32,12 +32,12 @@ func (d *DeSEC) Ping(ctx context.Context) fuel.ErrorWithStack {
 	return err
 }
 
-func (d *DeSEC) Update(ctx context.Context, del, create OutputRecordSet) fuel.ErrorWithStack {
+func (d *DeSEC) Update(ctx context.Context, del, create OutputRecordSet) (OutputRecordSet, fuel.ErrorWithStack) {
 	var domains []struct {
 		Name string `json:"name"`
 	}
 	if err := d.paginate(ctx, v1Domains, &domains); err != nil {
-		return err
+		return nil, err
 	}
 
 	domainSuffixes := map[string]string{}
@@ -90,7 +90,7 @@ func (d *DeSEC) Update(ctx context.Context, del, create OutputRecordSet) fuel.Er
 			&records,
 		)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		for _, record := range records {
@@ -176,7 +176,7 @@ func (d *DeSEC) Update(ctx context.Context, del, create OutputRecordSet) fuel.Er
 			v1Domains.ResolveReference(&url.URL{Path: url.PathEscape(domain) + "/rrsets/"}), body, nil,
 		)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
@@ -195,7 +195,7 @@ func (d *DeSEC) Update(ctx context.Context, del, create OutputRecordSet) fuel.Er
 			v1Domains.ResolveReference(&url.URL{Path: url.PathEscape(domain) + "/rrsets/"}), body, nil,
 		)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
@@ -214,9 +214,16 @@ func (d *DeSEC) Update(ctx context.Context, del, create OutputRecordSet) fuel.Er
 			v1Domains.ResolveReference(&url.URL{Path: url.PathEscape(domain) + "/rrsets/"}), body, nil,
 		)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	skipped := OutputRecordSet{}
+	for or := range create {
+		if _, ok := recordDomains[or]; !ok {
+			skipped[or] = struct{}{}
+		}
+	}
+
+	return skipped, nil
 }
@@ -0,0 +1,107 @@
+package docker
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Al2Klimov/FUeL.go"
+	"github.com/hashicorp/go-cleanhttp"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type container struct {
+	Id     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Labels map[string]string `json:"Labels"`
+}
+
+type event struct {
+	Type   string `json:"Type"`
+	Action string `json:"Action"`
+}
+
+type httpStatus uint16
+
+var _ error = httpStatus(0)
+
+func (hs httpStatus) Error() string {
+	return fmt.Sprintf("HTTP status: %d", int(hs))
+}
+
+// relevantEvents are the container events which may change the set of running containers' labels.
+var relevantEvents = []string{"start", "die", "destroy", "update", "rename", "pause", "unpause"}
+
+// containers lists all running containers.
+func (d *Docker) containers(ctx context.Context) ([]container, fuel.ErrorWithStack) {
+	var containers []container
+	err := d.get(ctx, "containers/json", nil, func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(&containers)
+	})
+
+	return containers, err
+}
+
+// waitForEvent blocks until a relevant container event happened since the given time.
+func (d *Docker) waitForEvent(ctx context.Context, since time.Time) fuel.ErrorWithStack {
+	filters, err := json.Marshal(map[string][]string{"type": {"container"}, "event": relevantEvents})
+	if err != nil {
+		return fuel.AttachStackToError(err, 0)
+	}
+
+	query := url.Values{
+		"since":   []string{strconv.FormatInt(since.Unix(), 10)},
+		"filters": []string{string(filters)},
+	}
+
+	return d.get(ctx, "events", query, func(body io.Reader) error {
+		var ev event
+		if err := json.NewDecoder(body).Decode(&ev); err != nil {
+			return err
+		}
+
+		ProviderLog(d).WithField("action", ev.Action).Trace("got container event")
+		return nil
+	})
+}
+
+func (d *Docker) get(ctx context.Context, path string, query url.Values, handle func(body io.Reader) error) fuel.ErrorWithStack {
+	d.once.Do(func() {
+		transport := cleanhttp.DefaultPooledTransport()
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", d.Socket)
+		}
+
+		d.client = &http.Client{Transport: transport}
+	})
+
+	// The host is irrelevant, we always talk to the socket.
+	uri := &url.URL{Scheme: "http", Host: "docker", Path: "/" + path, RawQuery: query.Encode()}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", uri.String(), nil)
+	if err != nil {
+		return fuel.AttachStackToError(err, 0)
+	}
+
+	response, err := d.client.Do(req)
+	logger := ProviderLog(d).WithField("url", uri.RequestURI())
+
+	if err != nil {
+		logger.WithError(err).Debug("performed HTTP request")
+		return fuel.AttachStackToError(err, 0)
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	logger.WithField("status", response.StatusCode).Debug("performed HTTP request")
+
+	if response.StatusCode > 299 {
+		return fuel.AttachStackToError(httpStatus(response.StatusCode), 0)
+	}
+
+	return fuel.AttachStackToError(handle(response.Body), 0)
+}
@@ -0,0 +1,174 @@
+package docker
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"fmt"
+	"github.com/Al2Klimov/FUeL.go"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Docker discovers the services of running containers' hosts via labels like
+// "tlsautomate.hosts=mail.example.com,smtp.example.com" and "tlsautomate.ports=25/tcp,465/tcp".
+type Docker struct {
+	Numbered
+
+	// Socket is the Docker Engine API's unix socket, e.g. /var/run/docker.sock.
+	Socket string
+	// LabelPrefix defaults to "tlsautomate".
+	LabelPrefix string
+
+	once         sync.Once
+	client       *http.Client
+	lastServices HostServices
+}
+
+var _ Discovery = (*Docker)(nil)
+
+func (*Docker) Kind() string {
+	return "Docker"
+}
+
+func (d *Docker) Ping(ctx context.Context) fuel.ErrorWithStack {
+	return d.get(ctx, "_ping", nil, func(body io.Reader) error {
+		_, err := io.Copy(ioutil.Discard, body)
+		return err
+	})
+}
+
+// Discover lists the running containers and, after that, on every relevant container event
+// until the result changes. On errors, e.g. while the daemon restarts, it reconnects with backoff
+// and lists the containers again as events may have been missed meanwhile.
+func (d *Docker) Discover(ctx context.Context, since time.Time) (HostServices, time.Time, fuel.ErrorWithStack) {
+	var backoff Backoff
+
+	for {
+		if since != (time.Time{}) {
+			if err := d.waitForEvent(ctx, since); err != nil {
+				if err := backoff.Retry(ctx, d, err, "can't watch container events, keeping the last services"); err != nil {
+					return nil, time.Time{}, err
+				}
+
+				// List at once
+				since = time.Time{}
+				continue
+			}
+		}
+
+		since = time.Now()
+
+		containers, err := d.containers(ctx)
+		if err != nil {
+			if err := backoff.Retry(ctx, d, err, "can't list containers, keeping the last services"); err != nil {
+				return nil, time.Time{}, err
+			}
+
+			since = time.Time{}
+			continue
+		}
+
+		backoff.Reset()
+
+		services := d.discover(containers)
+		if d.lastServices != nil && reflect.DeepEqual(services, d.lastServices) {
+			ProviderLog(d).Trace("services didn't change")
+			continue
+		}
+
+		d.lastServices = services
+		return services, since, nil
+	}
+}
+
+func (d *Docker) discover(containers []container) HostServices {
+	prefix := d.LabelPrefix
+	if prefix == "" {
+		prefix = "tlsautomate"
+	}
+
+	hostServices := HostServices{}
+
+	for _, c := range containers {
+		hosts, hasHosts := c.Labels[prefix+".hosts"]
+		ports, hasPorts := c.Labels[prefix+".ports"]
+		if !hasHosts && !hasPorts {
+			continue
+		}
+
+		logger := ProviderLog(d).WithFields(log.Fields{"container": c.Id, "names": c.Names})
+
+		if !hasHosts {
+			logger.Warn("container has ports, but no hosts label")
+			continue
+		}
+
+		var services []string
+		for _, port := range splitLabel(ports) {
+			svc, err := parsePort(port)
+			if err != nil {
+				logger.WithError(err).Warn("can't parse port label")
+				continue
+			}
+
+			services = append(services, svc)
+		}
+
+		for _, host := range splitLabel(hosts) {
+			host = strings.ToLower(host)
+			perHost, ok := hostServices[host]
+			if !ok {
+				// Known, but maybe not served via TLS at all
+				perHost = map[string]struct{}{}
+				hostServices[host] = perHost
+			}
+
+			for _, svc := range services {
+				perHost[svc] = struct{}{}
+			}
+		}
+
+		logger.WithFields(log.Fields{"hosts": hosts, "services": services}).Trace("discovered container")
+	}
+
+	return hostServices
+}
+
+// parsePort translates e.g. "25/tcp" or just "443" into "_25._tcp" or "_443._tcp".
+func parsePort(port string) (string, error) {
+	proto := "tcp"
+	if i := strings.LastIndexByte(port, '/'); i >= 0 {
+		proto = strings.ToLower(port[i+1:])
+		port = port[:i]
+	}
+
+	if proto != "tcp" && proto != "udp" && proto != "sctp" {
+		return "", fmt.Errorf("bad protocol: %s", proto)
+	}
+
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("_%d._%s", p, proto), nil
+}
+
+// splitLabel splits a comma separated label value.
+func splitLabel(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,264 @@
+package docker
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// standIn serves containers and, per request to /events, one of events via a unix socket.
+// Once events is closed, every events stream ends at once. It fails the next fails requests
+// and signals every containers listing via listed.
+type standIn struct {
+	sync.Mutex
+
+	containers []container
+	events     chan event
+	listed     chan struct{}
+	fails      int
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	fail := s.fails > 0
+	if fail {
+		s.fails--
+	}
+	s.Unlock()
+
+	if fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/_ping":
+		_, _ = w.Write([]byte("OK"))
+	case "/containers/json":
+		s.Lock()
+		defer s.Unlock()
+
+		_ = json.NewEncoder(w).Encode(s.containers)
+
+		select {
+		case s.listed <- struct{}{}:
+		default:
+		}
+	case "/events":
+		if r.URL.Query().Get("since") == "" || r.URL.Query().Get("filters") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case ev, ok := <-s.events:
+			if ok {
+				_ = json.NewEncoder(w).Encode(ev)
+			}
+		case <-r.Context().Done():
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *standIn) set(containers ...container) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.containers = containers
+}
+
+func TestDocker_Discover(t *testing.T) {
+	dkr := &standIn{events: make(chan event), listed: make(chan struct{}, 1)}
+	dkr.set(container{Id: "1", Labels: map[string]string{"tlsautomate.hosts": "a.example.com", "tlsautomate.ports": "25"}})
+
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	serve(t, socket, dkr)
+
+	d := &Docker{Numbered: Numbered{Nr: 1}, Socket: socket}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := d.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	services, asOf, err := d.Discover(ctx, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectServices(t, services, HostServices{"a.example.com": {"_25._tcp": {}}})
+	<-dkr.listed
+
+	type discovered struct {
+		services HostServices
+		err      error
+	}
+
+	done := make(chan discovered, 1)
+	go func() {
+		services, _, err := d.Discover(ctx, asOf)
+		done <- discovered{services, err}
+	}()
+
+	// The first event doesn't change anything, the second one does.
+	dkr.events <- event{Type: "container", Action: "update"}
+	<-dkr.listed
+
+	dkr.set(
+		container{Id: "1", Labels: map[string]string{"tlsautomate.hosts": "a.example.com", "tlsautomate.ports": "25"}},
+		container{Id: "2", Labels: map[string]string{"tlsautomate.hosts": "b.example.com", "tlsautomate.ports": "443"}},
+	)
+
+	dkr.events <- event{Type: "container", Action: "start"}
+	<-dkr.listed
+
+	result := <-done
+	if result.err != nil {
+		t.Fatal(result.err)
+	}
+
+	expectServices(t, result.services, HostServices{"a.example.com": {"_25._tcp": {}}, "b.example.com": {"_443._tcp": {}}})
+}
+
+func TestDocker_Discover_Unavailable(t *testing.T) {
+	MinBackoff, MaxBackoff = time.Millisecond, time.Millisecond
+
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	d := &Docker{Numbered: Numbered{Nr: 1}, Socket: socket}
+
+	if err := d.Ping(context.Background()); err == nil {
+		t.Error("expected an error for a missing socket")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	_, _, err := d.Discover(ctx, time.Time{})
+	cancel()
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a missing socket to be retried until %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	a := container{Id: "1", Labels: map[string]string{"tlsautomate.hosts": "a.example.com", "tlsautomate.ports": "25"}}
+	b := container{Id: "2", Labels: map[string]string{"tlsautomate.hosts": "b.example.com", "tlsautomate.ports": "443"}}
+
+	dkr := &standIn{events: make(chan event), listed: make(chan struct{}, 1), fails: 2}
+	dkr.set(a)
+	stop := serve(t, socket, dkr)
+
+	asOf := expectDiscover(t, d, time.Time{}, HostServices{"a.example.com": {"_25._tcp": {}}})
+
+	// The events stream ends
+	dkr.set(a, b)
+	close(dkr.events)
+
+	asOf = expectDiscover(t, d, asOf, HostServices{"a.example.com": {"_25._tcp": {}}, "b.example.com": {"_443._tcp": {}}})
+
+	// The daemon restarts, meanwhile a's container stops
+	stop()
+
+	restarted := &standIn{events: make(chan event), listed: make(chan struct{}, 1)}
+	restarted.set(b)
+	close(restarted.events)
+	serve(t, socket, restarted)
+
+	expectDiscover(t, d, asOf, HostServices{"b.example.com": {"_443._tcp": {}}})
+}
+
+func TestDocker_discover(t *testing.T) {
+	d := &Docker{Numbered: Numbered{Nr: 1}, LabelPrefix: "custom"}
+
+	services := d.discover([]container{
+		{Id: "mail", Labels: map[string]string{
+			"custom.hosts": "Mail.example.com, smtp.example.com,",
+			"custom.ports": "25/tcp,465, 53/UDP,132/sctp,bad,70000,25/icmp",
+		}},
+		{Id: "web", Labels: map[string]string{"custom.hosts": "mail.example.com", "custom.ports": "443"}},
+		{Id: "no TLS", Labels: map[string]string{"custom.hosts": "www.example.com"}},
+		{Id: "no hosts", Labels: map[string]string{"custom.ports": "443"}},
+		{Id: "default prefix", Labels: map[string]string{"tlsautomate.hosts": "x.example.com", "tlsautomate.ports": "443"}},
+		{Id: "unlabeled"},
+	})
+
+	mail := map[string]struct{}{"_25._tcp": {}, "_465._tcp": {}, "_53._udp": {}, "_132._sctp": {}}
+
+	expectServices(t, services, HostServices{
+		"mail.example.com": {"_25._tcp": {}, "_465._tcp": {}, "_53._udp": {}, "_132._sctp": {}, "_443._tcp": {}},
+		"smtp.example.com": mail,
+		"www.example.com":  {},
+	})
+}
+
+func TestParsePort(t *testing.T) {
+	for port, expected := range map[string]string{
+		"25":       "_25._tcp",
+		"25/tcp":   "_25._tcp",
+		"53/udp":   "_53._udp",
+		"132/SCTP": "_132._sctp",
+		"25/icmp":  "",
+		"70000":    "",
+		"smtp":     "",
+		"/tcp":     "",
+	} {
+		actual, err := parsePort(port)
+		if expected == "" {
+			if err == nil {
+				t.Errorf("parsePort(%q): expected an error, got %q", port, actual)
+			}
+		} else if err != nil {
+			t.Errorf("parsePort(%q): %s", port, err.Error())
+		} else if actual != expected {
+			t.Errorf("parsePort(%q): expected %q, got %q", port, expected, actual)
+		}
+	}
+}
+
+// serve serves handler via a new unix socket until the returned function is called.
+func serve(t *testing.T, socket string, handler http.Handler) func() {
+	t.Helper()
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &http.Server{Handler: handler}
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	return func() { _ = srv.Close() }
+}
+
+func expectDiscover(t *testing.T, d *Docker, since time.Time, expected HostServices) time.Time {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	services, asOf, err := d.Discover(ctx, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectServices(t, services, expected)
+	return asOf
+}
+
+func expectServices(t *testing.T, actual, expected HostServices) {
+	t.Helper()
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
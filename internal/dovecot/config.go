@@ -0,0 +1,162 @@
+package dovecot
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/Al2Klimov/FUeL.go"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// node is a Dovecot config setting like "ssl = yes" or a section like "service imap-login { ... }".
+type node struct {
+	key   string
+	value string
+	// name is the one of a section, e.g. "imap-login".
+	name     string
+	children []node
+	section  bool
+}
+
+// config is a parsed Dovecot config with all includes resolved.
+type config struct {
+	nodes []node
+	// files are all config files read.
+	files []string
+	// dirs are the directories include globs look into.
+	dirs []string
+}
+
+// parseConfig parses file and everything it includes.
+func parseConfig(file string) (*config, fuel.ErrorWithStack) {
+	cfg := &config{}
+	nodes, rest, err := cfg.parseFile(file, false, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if rest != 0 {
+		return nil, fuel.AttachStackToError(fmt.Errorf("%s: unbalanced braces", file), 0)
+	}
+
+	cfg.nodes = nodes
+	return cfg, nil
+}
+
+// parseFile parses file and returns how many sections it left open. Included files must be balanced.
+func (c *config) parseFile(file string, try bool, depth int) ([]node, int, fuel.ErrorWithStack) {
+	if depth > 32 {
+		return nil, 0, fuel.AttachStackToError(fmt.Errorf("%s: includes nested too deeply", file), 0)
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		if try {
+			return nil, 0, nil
+		}
+
+		return nil, 0, fuel.AttachStackToError(err, 0)
+	}
+
+	c.files = append(c.files, file)
+
+	var stack [][]node
+	var open []node
+	var current []node
+	lines := bufio.NewScanner(bytes.NewReader(content))
+
+	for lines.Scan() {
+		line := strings.TrimSpace(stripComment(lines.Text()))
+
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "!include"):
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+
+			pattern := strings.Trim(fields[1], "\"")
+			if !path.IsAbs(pattern) {
+				pattern = path.Join(path.Dir(file), pattern)
+			}
+
+			c.dirs = append(c.dirs, path.Dir(pattern))
+
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, 0, fuel.AttachStackToError(err, 0)
+			}
+
+			for _, match := range matches {
+				included, rest, err := c.parseFile(match, fields[0] == "!include_try", depth+1)
+				if err != nil {
+					return nil, 0, err
+				}
+
+				if rest != 0 {
+					return nil, 0, fuel.AttachStackToError(fmt.Errorf("%s: unbalanced braces", match), 0)
+				}
+
+				current = append(current, included...)
+			}
+		case line == "}":
+			if len(stack) < 1 {
+				return nil, 0, fuel.AttachStackToError(fmt.Errorf("%s: unexpected \"}\"", file), 0)
+			}
+
+			section := open[len(open)-1]
+			section.children = current
+			open = open[:len(open)-1]
+			current = append(stack[len(stack)-1], section)
+			stack = stack[:len(stack)-1]
+		case strings.HasSuffix(line, "{"):
+			fields := strings.Fields(strings.TrimSuffix(line, "{"))
+			if len(fields) < 1 {
+				return nil, 0, fuel.AttachStackToError(fmt.Errorf("%s: section name missing", file), 0)
+			}
+
+			section := node{key: fields[0], section: true}
+			if len(fields) > 1 {
+				section.name = strings.Trim(strings.Join(fields[1:], " "), "\"")
+			}
+
+			stack = append(stack, current)
+			open = append(open, section)
+			current = nil
+		default:
+			if eq := strings.Index(line, "="); eq > 0 {
+				current = append(current, node{
+					key:   strings.TrimSpace(line[:eq]),
+					value: strings.Trim(strings.TrimSpace(line[eq+1:]), "\""),
+				})
+			}
+		}
+	}
+
+	if err := lines.Err(); err != nil {
+		return nil, 0, fuel.AttachStackToError(err, 0)
+	}
+
+	return current, len(stack), nil
+}
+
+// stripComment removes a trailing "# ..." outside of quotes.
+func stripComment(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case '#':
+			if !quoted {
+				return line[:i]
+			}
+		}
+	}
+
+	return line
+}
@@ -0,0 +1,256 @@
+package dovecot
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"github.com/Al2Klimov/FUeL.go"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loginServices are the login services of the protocols and their default inet listeners' ports.
+var loginServices = map[string]struct {
+	service   string
+	listeners map[string]uint16
+}{
+	"imap":       {"imap-login", map[string]uint16{"imap": 143, "imaps": 993}},
+	"pop3":       {"pop3-login", map[string]uint16{"pop3": 110, "pop3s": 995}},
+	"submission": {"submission-login", map[string]uint16{"submission": 587}},
+	"sieve":      {"managesieve-login", map[string]uint16{"sieve": 4190}},
+}
+
+// Dovecot reads the certificates of a Dovecot config
+// and discovers the ports of the enabled protocols for all SANs of these certificates (in addition to their other ones).
+type Dovecot struct {
+	Numbered
+
+	// Config is the main config file, e.g. /etc/dovecot/dovecot.conf.
+	Config string
+}
+
+var _ Input = (*Dovecot)(nil)
+var _ AdditiveDiscovery = (*Dovecot)(nil)
+
+func (*Dovecot) Kind() string {
+	return "Dovecot"
+}
+
+// Additive as e.g. a www SAN of the mail certificate may also be served by a web server.
+func (*Dovecot) Additive() {
+}
+
+func (d *Dovecot) Ping(context.Context) fuel.ErrorWithStack {
+	_, err := parseConfig(d.Config)
+	return err
+}
+
+func (d *Dovecot) Poll(ctx context.Context, since time.Time) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
+	return PollFiles(ctx, d, since, d.dirs, func(since time.Time) (
+		[]*x509.Certificate, bool, time.Time, fuel.ErrorWithStack,
+	) {
+		certs, _, changed, asOf, err := d.read(since)
+		return certs, changed, asOf, err
+	})
+}
+
+func (d *Dovecot) Discover(ctx context.Context, since time.Time) (HostServices, time.Time, fuel.ErrorWithStack) {
+	var services HostServices
+	_, asOf, err := PollFiles(ctx, d, since, d.dirs, func(since time.Time) (
+		[]*x509.Certificate, bool, time.Time, fuel.ErrorWithStack,
+	) {
+		_, current, changed, asOf, err := d.read(since)
+		services = current
+		return nil, changed, asOf, err
+	})
+
+	return services, asOf, err
+}
+
+// read returns the leaves of all certificates and the enabled services per SAN
+// unless neither the config nor the certificates changed since the given time.
+func (d *Dovecot) read(since time.Time) (
+	certs []*x509.Certificate, services HostServices, changed bool, asOf time.Time, err fuel.ErrorWithStack,
+) {
+	cfg, errPC := parseConfig(d.Config)
+	if errPC != nil {
+		ProviderLog(d).WithError(errPC).Warn("can't parse config, assuming error is temporary")
+		return nil, nil, false, since, nil
+	}
+
+	certFiles := d.certFiles(cfg)
+
+	mt, err := ModTime(append(append(append([]string(nil), cfg.files...), cfg.dirs...), certFiles...)...)
+	if err != nil {
+		return nil, nil, false, time.Time{}, err
+	}
+
+	if !mt.After(since) {
+		ProviderLog(d).WithFields(log.Fields{
+			"mtime": mt, "since": since,
+		}).Trace("config's and certificates' mod times didn't change")
+		return nil, nil, false, since, nil
+	}
+
+	enabled := d.services(cfg)
+	services = HostServices{}
+
+	for _, file := range certFiles {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				ProviderLog(d).WithField("file", file).Debug("certificate doesn't exist, skipping")
+				continue
+			}
+
+			return nil, nil, false, time.Time{}, fuel.AttachStackToError(err, 0)
+		}
+
+		leaf, err := ParseLeaf(content)
+		if err != nil {
+			ProviderLog(d).WithError(err).WithField("file", file).Warn("can't parse PEM")
+			continue
+		}
+
+		certs = append(certs, leaf)
+
+		for _, san := range leaf.DNSNames {
+			san = strings.ToLower(san)
+			perHost, ok := services[san]
+			if !ok {
+				perHost = map[string]struct{}{}
+				services[san] = perHost
+			}
+
+			for svc := range enabled {
+				perHost[svc] = struct{}{}
+			}
+		}
+	}
+
+	return certs, services, true, mt, nil
+}
+
+// dirs returns the directories of all config files, include globs and certificates.
+func (d *Dovecot) dirs() []string {
+	cfg, err := parseConfig(d.Config)
+	if err != nil {
+		return DirsOf(d.Config)
+	}
+
+	return append(append(DirsOf(cfg.files...), cfg.dirs...), DirsOf(d.certFiles(cfg)...)...)
+}
+
+// certFiles returns the files of all ssl_cert (2.3) and ssl_server_cert_file (2.4) settings in any section.
+func (d *Dovecot) certFiles(cfg *config) []string {
+	seen := map[string]struct{}{}
+	var files []string
+
+	var walk func([]node)
+	walk = func(nodes []node) {
+		for _, n := range nodes {
+			if n.section {
+				walk(n.children)
+				continue
+			}
+
+			var file string
+			switch n.key {
+			case "ssl_cert":
+				// The "<" means "read from file", otherwise it's inline PEM which we can't watch.
+				if strings.HasPrefix(n.value, "<") {
+					file = strings.TrimSpace(n.value[1:])
+				}
+			case "ssl_server_cert_file":
+				file = n.value
+			}
+
+			if file != "" {
+				if !path.IsAbs(file) {
+					file = path.Join(path.Dir(d.Config), file)
+				}
+
+				if _, ok := seen[file]; !ok {
+					seen[file] = struct{}{}
+					files = append(files, file)
+				}
+			}
+		}
+	}
+
+	walk(cfg.nodes)
+	return files
+}
+
+// services returns the TLS-capable services of the enabled protocols, e.g. "_993._tcp".
+func (d *Dovecot) services(cfg *config) map[string]struct{} {
+	protocols := []string{"imap", "pop3", "lmtp"}
+	ssl := "yes"
+	listeners := map[string]map[string]uint16{}
+
+	for _, login := range loginServices {
+		listeners[login.service] = map[string]uint16{}
+		for name, port := range login.listeners {
+			listeners[login.service][name] = port
+		}
+	}
+
+	for _, n := range cfg.nodes {
+		switch {
+		case n.key == "protocols" && !n.section:
+			var current []string
+			for _, protocol := range strings.Fields(n.value) {
+				if protocol == "$protocols" {
+					current = append(current, protocols...)
+				} else {
+					current = append(current, protocol)
+				}
+			}
+
+			protocols = current
+		case n.key == "ssl" && !n.section:
+			ssl = n.value
+		case n.key == "service" && n.section:
+			perService, ok := listeners[n.name]
+			if !ok {
+				continue
+			}
+
+			for _, listener := range n.children {
+				if listener.section && listener.key == "inet_listener" {
+					for _, setting := range listener.children {
+						if setting.key == "port" && !setting.section {
+							if port, err := strconv.ParseUint(setting.value, 10, 16); err == nil {
+								perService[listener.name] = uint16(port)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	services := map[string]struct{}{}
+	if ssl == "no" {
+		return services
+	}
+
+	// STARTTLS and implicit TLS listeners alike
+	for _, protocol := range protocols {
+		if login, ok := loginServices[protocol]; ok {
+			for _, port := range listeners[login.service] {
+				if port > 0 {
+					services[fmt.Sprintf("_%d._tcp", port)] = struct{}{}
+				}
+			}
+		}
+	}
+
+	return services
+}
@@ -0,0 +1,144 @@
+package haproxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"github.com/Al2Klimov/FUeL.go"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// crtListEntry is a crt-list line.
+type crtListEntry struct {
+	file string
+	// filters are SNI filters, negative ones are prefixed with "!".
+	filters []string
+}
+
+// parseCrtList parses a crt-list file, i.e. lines like "<crtfile> [<bind options>] [<sni filter> ...]".
+// A missing file counts as empty.
+func parseCrtList(file string) ([]crtListEntry, fuel.ErrorWithStack) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, fuel.AttachStackToError(err, 0)
+	}
+
+	var entries []crtListEntry
+	lines := bufio.NewScanner(bytes.NewReader(content))
+
+	for nr := 1; lines.Scan(); nr++ {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		entry := crtListEntry{file: fields[0]}
+		fields = fields[1:]
+
+		if len(fields) > 0 && strings.HasPrefix(fields[0], "[") {
+			closed := false
+			for len(fields) > 0 && !closed {
+				closed = strings.HasSuffix(fields[0], "]")
+				fields = fields[1:]
+			}
+
+			if !closed {
+				return nil, fuel.AttachStackToError(fmt.Errorf("%s:%d: unterminated bind options", file, nr), 0)
+			}
+		}
+
+		entry.filters = fields
+		entries = append(entries, entry)
+	}
+
+	return entries, fuel.AttachStackToError(lines.Err(), 0)
+}
+
+// applyFilters returns a copy of leaf with only the DNS SANs HAProxy serves it for according to filters.
+// Without positive filters these are all SANs not excluded by negative ones,
+// otherwise the positive filters covered by any SAN.
+func applyFilters(leaf *x509.Certificate, filters []string) *x509.Certificate {
+	if len(filters) < 1 {
+		return leaf
+	}
+
+	var positive, negative []string
+	for _, filter := range filters {
+		if strings.HasPrefix(filter, "!") {
+			negative = append(negative, strings.ToLower(filter[1:]))
+		} else {
+			positive = append(positive, strings.ToLower(filter))
+		}
+	}
+
+	names := leaf.DNSNames
+	if len(positive) > 0 {
+		names = nil
+
+		for _, filter := range positive {
+			for _, san := range leaf.DNSNames {
+				if matches(strings.ToLower(san), filter) {
+					names = append(names, filter)
+					break
+				}
+			}
+		}
+	}
+
+	filtered := *leaf
+	filtered.DNSNames = nil
+
+Names:
+	for _, name := range names {
+		for _, filter := range negative {
+			if matches(filter, strings.ToLower(name)) {
+				continue Names
+			}
+		}
+
+		filtered.DNSNames = append(filtered.DNSNames, name)
+	}
+
+	return &filtered
+}
+
+// mergeNames returns the union of a and b.
+func mergeNames(a, b []string) []string {
+	merged := append([]string(nil), a...)
+
+Names:
+	for _, name := range b {
+		for _, present := range merged {
+			if name == present {
+				continue Names
+			}
+		}
+
+		merged = append(merged, name)
+	}
+
+	return merged
+}
+
+// matches tells whether the (possibly wildcard) pattern covers name.
+func matches(pattern, name string) bool {
+	if pattern == name {
+		return true
+	}
+
+	if strings.HasPrefix(pattern, "*.") {
+		if dot := strings.Index(name, "."); dot > 0 && name[:dot] != "*" {
+			return name[dot+1:] == pattern[2:]
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,230 @@
+package haproxy
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"crypto/x509"
+	"github.com/Al2Klimov/FUeL.go"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// ignoredSuffixes are the ones of files in crt directories HAProxy doesn't load as certificates.
+var ignoredSuffixes = []string{".key", ".ocsp", ".issuer", ".sctl"}
+
+// bundleSuffixes are the ones of multi-cert bundles' files HAProxy loads if the named file doesn't exist.
+var bundleSuffixes = []string{".rsa", ".ecdsa", ".dsa"}
+
+type HaProxy struct {
+	Numbered
+
+	// CrtLists are crt-list files.
+	CrtLists []string
+	// Crts are PEM bundles or directories of such as given to "crt".
+	Crts []string
+	// CrtBase is HAProxy's crt-base. Relative paths are resolved against it.
+	CrtBase string
+}
+
+var _ Input = (*HaProxy)(nil)
+
+func (*HaProxy) Kind() string {
+	return "HAProxy"
+}
+
+func (h *HaProxy) Ping(context.Context) fuel.ErrorWithStack {
+	for _, dir := range DirsOf(append(append([]string(nil), h.CrtLists...), h.resolveAll(h.Crts)...)...) {
+		if _, err := ioutil.ReadDir(dir); err != nil {
+			return fuel.AttachStackToError(err, 0)
+		}
+	}
+
+	return nil
+}
+
+func (h *HaProxy) Poll(ctx context.Context, since time.Time) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
+	return PollFiles(ctx, h, since, h.dirs, h.read)
+}
+
+func (h *HaProxy) read(since time.Time) ([]*x509.Certificate, bool, time.Time, fuel.ErrorWithStack) {
+	entries, err := h.entries()
+	if err != nil {
+		return nil, false, time.Time{}, err
+	}
+
+	files := append(append([]string(nil), h.CrtLists...), h.resolveAll(h.Crts)...)
+	for _, entry := range entries {
+		files = append(files, entry.file)
+	}
+
+	mt, err := ModTime(files...)
+	if err != nil {
+		return nil, false, time.Time{}, err
+	}
+
+	if !mt.After(since) {
+		ProviderLog(h).WithFields(log.Fields{
+			"mtime": mt, "since": since,
+		}).Trace("certificates' mod times didn't change")
+		return nil, false, since, nil
+	}
+
+	var certs []*x509.Certificate
+	byRaw := map[string]int{}
+
+	for _, entry := range entries {
+		content, err := ioutil.ReadFile(entry.file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				ProviderLog(h).WithField("file", entry.file).Debug("certificate doesn't exist, skipping")
+				continue
+			}
+
+			return nil, false, time.Time{}, fuel.AttachStackToError(err, 0)
+		}
+
+		// Bundles are concatenated cert+key+chain, the leaf comes first.
+		leaf, err := ParseLeaf(content)
+		if err != nil {
+			ProviderLog(h).WithError(err).WithField("file", entry.file).Warn("can't parse PEM")
+			continue
+		}
+
+		filtered := applyFilters(leaf, entry.filters)
+		if len(filtered.DNSNames) < len(leaf.DNSNames) {
+			ProviderLog(h).WithFields(log.Fields{
+				"file": entry.file, "filters": entry.filters, "sans": leaf.DNSNames, "served": filtered.DNSNames,
+			}).Trace("applied SNI filters")
+		}
+
+		// The same certificate may be served for different SNIs via multiple crt-list lines.
+		if i, ok := byRaw[string(leaf.Raw)]; ok {
+			merged := *certs[i]
+			merged.DNSNames = mergeNames(merged.DNSNames, filtered.DNSNames)
+			certs[i] = &merged
+		} else {
+			byRaw[string(leaf.Raw)] = len(certs)
+			certs = append(certs, filtered)
+		}
+	}
+
+	return certs, true, mt, nil
+}
+
+// dirs returns the directories of the crt-lists, the crt directories and the ones of all certificates.
+func (h *HaProxy) dirs() []string {
+	dirs := DirsOf(h.CrtLists...)
+	for _, crt := range h.resolveAll(h.Crts) {
+		if st, err := os.Stat(crt); err == nil && st.IsDir() {
+			dirs = append(dirs, crt)
+		} else {
+			dirs = append(dirs, DirsOf(crt)...)
+		}
+	}
+
+	entries, err := h.entries()
+	if err != nil {
+		ProviderLog(h).WithError(err).Warn("can't list certificates")
+	}
+
+	for _, entry := range entries {
+		dirs = append(dirs, DirsOf(entry.file)...)
+	}
+
+	return dirs
+}
+
+// entries returns the certificates of all crt-lists and crts.
+func (h *HaProxy) entries() ([]crtListEntry, fuel.ErrorWithStack) {
+	var entries []crtListEntry
+	for _, crtList := range h.CrtLists {
+		list, err := parseCrtList(crtList)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range list {
+			for _, file := range bundleFiles(h.resolve(entry.file)) {
+				entries = append(entries, crtListEntry{file: file, filters: entry.filters})
+			}
+		}
+	}
+
+	for _, crt := range h.resolveAll(h.Crts) {
+		st, err := os.Stat(crt)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fuel.AttachStackToError(err, 0)
+		}
+
+		if err != nil || !st.IsDir() {
+			for _, file := range bundleFiles(crt) {
+				entries = append(entries, crtListEntry{file: file})
+			}
+
+			continue
+		}
+
+		files, errRD := ioutil.ReadDir(crt)
+		if errRD != nil {
+			return nil, fuel.AttachStackToError(errRD, 0)
+		}
+
+	Files:
+		for _, file := range files {
+			if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+				continue
+			}
+
+			for _, suffix := range ignoredSuffixes {
+				if strings.HasSuffix(file.Name(), suffix) {
+					continue Files
+				}
+			}
+
+			entries = append(entries, crtListEntry{file: path.Join(crt, file.Name())})
+		}
+	}
+
+	return entries, nil
+}
+
+func (h *HaProxy) resolveAll(files []string) []string {
+	resolved := make([]string, 0, len(files))
+	for _, file := range files {
+		resolved = append(resolved, h.resolve(file))
+	}
+
+	return resolved
+}
+
+func (h *HaProxy) resolve(file string) string {
+	if h.CrtBase == "" || path.IsAbs(file) {
+		return file
+	}
+
+	return path.Join(h.CrtBase, file)
+}
+
+// bundleFiles returns file itself or, if it doesn't exist, the existing files of the multi-cert bundle it names.
+func bundleFiles(file string) []string {
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		return []string{file}
+	}
+
+	var files []string
+	for _, suffix := range bundleSuffixes {
+		if _, err := os.Stat(file + suffix); err == nil {
+			files = append(files, file+suffix)
+		}
+	}
+
+	if files == nil {
+		files = []string{file}
+	}
+
+	return files
+}
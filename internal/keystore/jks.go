@@ -0,0 +1,140 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+)
+
+const (
+	jksMagic            = 0xFEEDFEED
+	jksPrivateKeyEntry  = 1
+	jksTrustedCertEntry = 2
+)
+
+var errJksPassword = errors.New("keystore password incorrect or keystore corrupt")
+
+// decodeJks returns the leaves of all private key entries and all trusted certificates of a Java keystore.
+// Private keys aren't decrypted, the password is only used to verify the keystore's integrity (if given).
+func decodeJks(data []byte, password *string) ([]*x509.Certificate, error) {
+	if len(data) < sha1.Size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	body := data[:len(data)-sha1.Size]
+
+	if password != nil {
+		hash := sha1.New()
+		for _, c := range utf16.Encode([]rune(*password)) {
+			_, _ = hash.Write([]byte{byte(c >> 8), byte(c)})
+		}
+
+		_, _ = hash.Write([]byte("Mighty Aphrodite"))
+		_, _ = hash.Write(body)
+
+		if !bytes.Equal(hash.Sum(nil), data[len(body):]) {
+			return nil, errJksPassword
+		}
+	}
+
+	r := &jksReader{bytes.NewReader(body), nil}
+
+	if r.uint32() != jksMagic {
+		return nil, errors.New("not a JKS keystore")
+	}
+
+	version := r.uint32()
+	if version != 1 && version != 2 {
+		return nil, fmt.Errorf("unsupported JKS version: %d", version)
+	}
+
+	var leaves []*x509.Certificate
+	for entries := r.uint32(); entries > 0 && r.err == nil; entries-- {
+		tag := r.uint32()
+		r.utf()    // alias
+		r.uint64() // timestamp
+
+		var chain uint32
+		switch tag {
+		case jksPrivateKeyEntry:
+			r.bytes() // encrypted key
+			chain = r.uint32()
+		case jksTrustedCertEntry:
+			chain = 1
+		default:
+			return nil, fmt.Errorf("unsupported JKS entry type: %d", tag)
+		}
+
+		for i := uint32(0); i < chain && r.err == nil; i++ {
+			if version == 2 {
+				r.utf() // certificate type
+			}
+
+			der := r.bytes()
+			if i == 0 && r.err == nil {
+				leaf, err := x509.ParseCertificate(der)
+				if err != nil {
+					return nil, err
+				}
+
+				leaves = append(leaves, leaf)
+			}
+		}
+	}
+
+	return leaves, r.err
+}
+
+// jksReader reads Java's DataOutputStream format and remembers the first error.
+type jksReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (jr *jksReader) read(n uint64) []byte {
+	if jr.err != nil {
+		return nil
+	}
+
+	if n > uint64(jr.r.Len()) {
+		jr.err = io.ErrUnexpectedEOF
+		return nil
+	}
+
+	buf := make([]byte, n)
+	_, jr.err = io.ReadFull(jr.r, buf)
+	return buf
+}
+
+func (jr *jksReader) uint32() uint32 {
+	if buf := jr.read(4); buf != nil {
+		return binary.BigEndian.Uint32(buf)
+	}
+
+	return 0
+}
+
+func (jr *jksReader) uint64() uint64 {
+	if buf := jr.read(8); buf != nil {
+		return binary.BigEndian.Uint64(buf)
+	}
+
+	return 0
+}
+
+func (jr *jksReader) utf() []byte {
+	if buf := jr.read(2); buf != nil {
+		return jr.read(uint64(binary.BigEndian.Uint16(buf)))
+	}
+
+	return nil
+}
+
+func (jr *jksReader) bytes() []byte {
+	return jr.read(uint64(jr.uint32()))
+}
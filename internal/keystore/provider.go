@@ -0,0 +1,141 @@
+package keystore
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"crypto/x509"
+	"encoding/binary"
+	"github.com/Al2Klimov/FUeL.go"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"software.sslmate.com/src/go-pkcs12"
+	"strings"
+	"time"
+)
+
+// Keystore reads a PKCS#12 or JKS keystore, the format is detected automatically.
+type Keystore struct {
+	Numbered
+
+	File string
+	// PasswordFile is optional for JKS keystores as their password only protects their integrity.
+	PasswordFile string
+}
+
+var _ Input = (*Keystore)(nil)
+
+func (*Keystore) Kind() string {
+	return "keystore"
+}
+
+func (k *Keystore) Ping(context.Context) fuel.ErrorWithStack {
+	if k.PasswordFile != "" {
+		if _, err := k.password(); err != nil {
+			return err
+		}
+	}
+
+	_, err := ioutil.ReadDir(path.Dir(k.File))
+	return fuel.AttachStackToError(err, 0)
+}
+
+func (k *Keystore) Poll(ctx context.Context, since time.Time) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
+	return PollFiles(ctx, k, since, func() []string { return DirsOf(k.files()...) }, k.read)
+}
+
+func (k *Keystore) read(since time.Time) ([]*x509.Certificate, bool, time.Time, fuel.ErrorWithStack) {
+	mt, err := ModTime(k.files()...)
+	if err != nil {
+		return nil, false, time.Time{}, err
+	}
+
+	if !mt.After(since) {
+		ProviderLog(k).WithFields(log.Fields{
+			"mtime": mt, "since": since,
+		}).Trace("keystore's mod time didn't change")
+		return nil, false, since, nil
+	}
+
+	data, errRF := ioutil.ReadFile(k.File)
+	if errRF != nil {
+		if os.IsNotExist(errRF) {
+			ProviderLog(k).Warn("keystore doesn't exist, assuming only temporarily")
+			return nil, false, mt, nil
+		}
+
+		return nil, false, time.Time{}, fuel.AttachStackToError(errRF, 0)
+	}
+
+	var password *string
+	if k.PasswordFile != "" {
+		pw, err := k.password()
+		if err != nil {
+			return nil, false, time.Time{}, err
+		}
+
+		password = &pw
+	}
+
+	var leaves []*x509.Certificate
+	var errDc error
+	var format string
+
+	if len(data) >= 4 && binary.BigEndian.Uint32(data) == jksMagic {
+		format = "JKS"
+		leaves, errDc = decodeJks(data, password)
+	} else {
+		format = "PKCS#12"
+		leaves, errDc = decodePkcs12(data, password)
+	}
+
+	if errDc != nil {
+		ProviderLog(k).WithError(errDc).WithField("format", format).
+			Warn("can't decode keystore, assuming error is temporary, keeping the last certificates")
+
+		// Re-read next time, maybe it was in the middle of being written
+		return nil, false, since, nil
+	}
+
+	ProviderLog(k).WithFields(log.Fields{"format": format, "leaves": len(leaves)}).Trace("decoded keystore")
+	return leaves, true, mt, nil
+}
+
+// files returns the keystore and the password file, if any.
+func (k *Keystore) files() []string {
+	if k.PasswordFile == "" {
+		return []string{k.File}
+	}
+
+	return []string{k.File, k.PasswordFile}
+}
+
+func (k *Keystore) password() (string, fuel.ErrorWithStack) {
+	pw, err := ioutil.ReadFile(k.PasswordFile)
+	if err != nil {
+		return "", fuel.AttachStackToError(err, 0)
+	}
+
+	return strings.TrimRight(string(pw), "\r\n"), nil
+}
+
+// decodePkcs12 returns the leaf of a keystore's private key or, if there's none (e.g. trust stores), all certificates.
+func decodePkcs12(data []byte, password *string) ([]*x509.Certificate, error) {
+	pw := ""
+	if password != nil {
+		pw = *password
+	}
+
+	_, leaf, _, err := pkcs12.DecodeChain(data, pw)
+	if err == nil {
+		return []*x509.Certificate{leaf}, nil
+	}
+
+	certs, errTS := pkcs12.DecodeTrustStore(data, pw)
+	if errTS != nil {
+		return nil, err
+	}
+
+	return certs, nil
+}
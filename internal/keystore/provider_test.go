@@ -0,0 +1,124 @@
+package keystore
+
+import (
+	. "TLSAutomate/internal"
+	"TLSAutomate/internal/test-certs"
+	"crypto/rand"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"software.sslmate.com/src/go-pkcs12"
+	"testing"
+	"time"
+)
+
+func TestKeystore_read(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keystore.p12")
+	k := &Keystore{Numbered: Numbered{Nr: 1}, File: file}
+
+	if err := ioutil.WriteFile(file, []byte("being written"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Also on re-reads, e.g. due to events on other files in the same directory
+	for i := 0; i < 2; i++ {
+		if _, changed, _, err := k.read(time.Time{}); changed || err != nil {
+			t.Fatalf("expected a broken keystore to be assumed temporary, got changed=%v, err=%v", changed, err)
+		}
+	}
+
+	a, _ := testcerts.New(t, "a.example.com", testcerts.Options{})
+
+	data, err := pkcs12.EncodeTrustStore(rand.Reader, []*x509.Certificate{a}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Written within the same mod time granularity
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	certs, changed, _, err := k.read(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !changed || len(certs) != 1 || certs[0].Subject.CommonName != "a.example.com" {
+		t.Errorf("expected a.example.com, got changed=%v and %d certs", changed, len(certs))
+	}
+}
+
+func TestDecodePkcs12_OpenSsl(t *testing.T) {
+	// openssl pkcs12 -export (3.x), i.e. PBES2 with AES-256-CBC, of www.example.com's key and chain
+	data, err := ioutil.ReadFile("testdata/openssl.p12")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pw := "changeit"
+	expectLeaves(t, data, &pw, "www.example.com")
+
+	pw = "wrong"
+	if _, err := decodePkcs12(data, &pw); err == nil {
+		t.Error("expected an error for a wrong password")
+	}
+}
+
+func TestDecodePkcs12_Legacy(t *testing.T) {
+	leaf, key := testcerts.New(t, "www.example.com", testcerts.Options{})
+	ca, _ := testcerts.New(t, "ca.example.com", testcerts.Options{})
+
+	// RC2-40 for certificates, 3DES for the key
+	data, err := pkcs12.LegacyRC2.Encode(key, leaf, []*x509.Certificate{ca}, "changeit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pw := "changeit"
+	expectLeaves(t, data, &pw, "www.example.com")
+
+	// The MAC's iterations, i.e. only the MAC fails
+	data[len(data)-1] ^= 1
+	if _, err := decodePkcs12(data, &pw); err == nil {
+		t.Error("expected an error for a wrong MAC")
+	}
+}
+
+func TestDecodePkcs12_TrustStore(t *testing.T) {
+	a, _ := testcerts.New(t, "a.example.com", testcerts.Options{})
+	b, _ := testcerts.New(t, "b.example.com", testcerts.Options{})
+
+	// Certificates with Java's trusted key usage attribute, no keys
+	data, err := pkcs12.Modern.EncodeTrustStore([]*x509.Certificate{a, b}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectLeaves(t, data, nil, "a.example.com", "b.example.com")
+}
+
+func expectLeaves(t *testing.T, data []byte, password *string, sans ...string) {
+	t.Helper()
+
+	leaves, err := decodePkcs12(data, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(leaves) != len(sans) {
+		t.Fatalf("expected %d leaves, got %d", len(sans), len(leaves))
+	}
+
+	for i, leaf := range leaves {
+		if leaf.Subject.CommonName != sans[i] {
+			t.Errorf("expected leaf #%d to be %s, got %s", i+1, sans[i], leaf.Subject.CommonName)
+		}
+	}
+}
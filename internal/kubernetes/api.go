@@ -0,0 +1,164 @@
+package kubernetes
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"github.com/Al2Klimov/FUeL.go"
+	"github.com/hashicorp/go-cleanhttp"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// list fetches all TLS secrets and returns the resource version to watch from.
+func (k *Kubernetes) list(ctx context.Context) (map[string]secret, string, fuel.ErrorWithStack) {
+	secrets := map[string]secret{}
+	query := url.Values{"limit": []string{"500"}}
+
+	for {
+		var page secretList
+		if err := k.get(ctx, k.secretsUrl(query), func(body io.Reader) error {
+			return json.NewDecoder(body).Decode(&page)
+		}); err != nil {
+			return nil, "", err
+		}
+
+		for _, s := range page.Items {
+			secrets[s.Metadata.Namespace+"/"+s.Metadata.Name] = s
+		}
+
+		if page.Metadata.Continue == "" {
+			return secrets, page.Metadata.ResourceVersion, nil
+		}
+
+		query.Set("continue", page.Metadata.Continue)
+	}
+}
+
+// watch streams changes of TLS secrets since resourceVersion to onEvent until the latter returns false.
+// Returns errGone if resourceVersion is too old.
+func (k *Kubernetes) watch(
+	ctx context.Context, resourceVersion string, onEvent func(*watchEvent) bool,
+) fuel.ErrorWithStack {
+	query := url.Values{
+		"watch":               []string{"1"},
+		"resourceVersion":     []string{resourceVersion},
+		"allowWatchBookmarks": []string{"true"},
+	}
+
+	return k.get(ctx, k.secretsUrl(query), func(body io.Reader) error {
+		dec := json.NewDecoder(body)
+
+		for {
+			var event watchEvent
+			if err := dec.Decode(&event); err != nil {
+				if err == io.EOF {
+					// The API server closes watches from time to time.
+					return nil
+				}
+
+				return err
+			}
+
+			if event.Type == "ERROR" {
+				if event.Object.Code == int(errGone) {
+					return errGone
+				}
+
+				return errors.New(event.Object.Message)
+			}
+
+			if !onEvent(&event) {
+				return nil
+			}
+		}
+	})
+}
+
+func (k *Kubernetes) get(ctx context.Context, uri *url.URL, handle func(body io.Reader) error) fuel.ErrorWithStack {
+	k.once.Do(k.init)
+	if k.initErr != nil {
+		return k.initErr
+	}
+
+	uri = k.base.ResolveReference(uri)
+	req := (&http.Request{Method: "GET", URL: uri, Header: http.Header{}}).WithContext(ctx)
+
+	if k.TokenFile != "" {
+		// Re-read every time as bound service account tokens get rotated.
+		token, err := ioutil.ReadFile(k.TokenFile)
+		if err != nil {
+			return fuel.AttachStackToError(err, 0)
+		}
+
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	response, err := k.client.Do(req)
+	logger := ProviderLog(k).WithField("url", uri.String())
+
+	if err != nil {
+		logger.WithError(err).Debug("performed HTTP request")
+		return fuel.AttachStackToError(err, 0)
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	logger.WithField("status", response.StatusCode).Debug("performed HTTP request")
+
+	if response.StatusCode > 299 {
+		return fuel.AttachStackToError(httpStatus(response.StatusCode), 0)
+	}
+
+	return fuel.AttachStackToError(handle(response.Body), 0)
+}
+
+// init defaults to the in-cluster config as far as not configured otherwise.
+func (k *Kubernetes) init() {
+	api := k.Api
+	if api == "" {
+		api = "https://" + net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"))
+
+		if k.TokenFile == "" {
+			k.TokenFile = serviceAccount + "token"
+		}
+
+		if k.CaFile == "" {
+			k.CaFile = serviceAccount + "ca.crt"
+		}
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(api, "/") + "/")
+	if err != nil {
+		k.initErr = fuel.AttachStackToError(err, 0)
+		return
+	}
+
+	k.base = base
+	tx := cleanhttp.DefaultPooledTransport()
+
+	if k.CaFile != "" {
+		ca, err := ioutil.ReadFile(k.CaFile)
+		if err != nil {
+			k.initErr = fuel.AttachStackToError(err, 0)
+			return
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			k.initErr = fuel.AttachStackToError(errors.New("CA file doesn't contain any certificates"), 0)
+			return
+		}
+
+		tx.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	k.client = &http.Client{Transport: tx}
+}
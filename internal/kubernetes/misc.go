@@ -0,0 +1,68 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net/url"
+)
+
+type httpStatus uint16
+
+var _ error = httpStatus(0)
+
+func (hs httpStatus) Error() string {
+	return fmt.Sprintf("HTTP status: %d", int(hs))
+}
+
+const (
+	serviceAccount = "/var/run/secrets/kubernetes.io/serviceaccount/"
+	tlsSecret      = "kubernetes.io/tls"
+)
+
+type objectMeta struct {
+	Namespace       string `json:"namespace"`
+	Name            string `json:"name"`
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+type secret struct {
+	Metadata objectMeta        `json:"metadata"`
+	Type     string            `json:"type"`
+	Data     map[string][]byte `json:"data"`
+}
+
+type secretList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+		Continue        string `json:"continue"`
+	} `json:"metadata"`
+	Items []secret `json:"items"`
+}
+
+// watchEvent's Object is a secret or, if Type is ERROR, a status.
+type watchEvent struct {
+	Type   string `json:"type"`
+	Object struct {
+		secret
+
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"object"`
+}
+
+// errGone signals an outdated resource version.
+var errGone = httpStatus(410)
+
+// secretsUrl returns the URL of the TLS secrets of interest relative to the API.
+func (k *Kubernetes) secretsUrl(query url.Values) *url.URL {
+	p := "api/v1/secrets"
+	if k.Namespace != "" {
+		p = "api/v1/namespaces/" + url.PathEscape(k.Namespace) + "/secrets"
+	}
+
+	query.Set("fieldSelector", "type="+tlsSecret)
+	if k.LabelSelector != "" {
+		query.Set("labelSelector", k.LabelSelector)
+	}
+
+	return &url.URL{Path: p, RawQuery: query.Encode()}
+}
@@ -0,0 +1,126 @@
+package kubernetes
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"crypto/x509"
+	"errors"
+	"github.com/Al2Klimov/FUeL.go"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type Kubernetes struct {
+	Numbered
+
+	// Api defaults to the in-cluster one, TokenFile and CaFile then to the service account's ones.
+	Api           string
+	TokenFile     string
+	CaFile        string
+	Namespace     string
+	LabelSelector string
+
+	once    sync.Once
+	initErr fuel.ErrorWithStack
+	base    *url.URL
+	client  *http.Client
+
+	secrets         map[string]secret
+	resourceVersion string
+}
+
+var _ Input = (*Kubernetes)(nil)
+
+func (*Kubernetes) Kind() string {
+	return "Kubernetes"
+}
+
+func (k *Kubernetes) Ping(ctx context.Context) fuel.ErrorWithStack {
+	_, _, err := k.list(ctx)
+	return err
+}
+
+// Poll lists all TLS secrets initially and blocks on a watch for changes on subsequent calls.
+// API errors are retried with exponential backoff.
+func (k *Kubernetes) Poll(ctx context.Context, _ time.Time) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
+	var backoff Backoff
+
+	for {
+		if k.resourceVersion == "" {
+			secrets, resourceVersion, err := k.list(ctx)
+			if err != nil {
+				if err := backoff.Retry(ctx, k, err, "can't list TLS secrets"); err != nil {
+					return nil, time.Time{}, err
+				}
+
+				continue
+			}
+
+			k.secrets = secrets
+			k.resourceVersion = resourceVersion
+
+			return k.certs(), time.Now(), nil
+		}
+
+		changed := false
+		err := k.watch(ctx, k.resourceVersion, func(event *watchEvent) bool {
+			s := &event.Object.secret
+			k.resourceVersion = s.Metadata.ResourceVersion
+
+			switch event.Type {
+			case "ADDED", "MODIFIED":
+				k.secrets[s.Metadata.Namespace+"/"+s.Metadata.Name] = *s
+			case "DELETED":
+				delete(k.secrets, s.Metadata.Namespace+"/"+s.Metadata.Name)
+			default:
+				// BOOKMARK
+				return true
+			}
+
+			ProviderLog(k).WithFields(log.Fields{
+				"event": event.Type, "namespace": s.Metadata.Namespace, "name": s.Metadata.Name,
+			}).Debug("TLS secret changed")
+
+			changed = true
+			return false
+		})
+
+		if err != nil {
+			if errors.Is(err, errGone) {
+				ProviderLog(k).Debug("resource version too old, re-listing")
+				k.resourceVersion = ""
+				continue
+			}
+
+			if err := backoff.Retry(ctx, k, err, "can't watch TLS secrets"); err != nil {
+				return nil, time.Time{}, err
+			}
+
+			continue
+		}
+
+		backoff.Reset()
+
+		if changed {
+			return k.certs(), time.Now(), nil
+		}
+	}
+}
+
+func (k *Kubernetes) certs() []*x509.Certificate {
+	var certs []*x509.Certificate
+	for key, s := range k.secrets {
+		leaf, err := ParseLeaf(s.Data["tls.crt"])
+		if err != nil {
+			ProviderLog(k).WithError(err).WithField("secret", key).Warn("can't parse PEM")
+			continue
+		}
+
+		certs = append(certs, leaf)
+	}
+
+	return certs
+}
@@ -0,0 +1,152 @@
+package kubernetes
+
+import (
+	. "TLSAutomate/internal"
+	"TLSAutomate/internal/test-certs"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKubernetes_Poll(t *testing.T) {
+	MinBackoff, MaxBackoff = time.Millisecond, time.Millisecond
+
+	a := newSecret(t, "a", "a.example.com")
+	b := newSecret(t, "b", "b.example.com")
+	c := newSecret(t, "c", "c.example.com")
+
+	var mtx sync.Mutex
+	var requests []string
+	lists := 0
+	watches := map[string]int{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		query := r.URL.Query()
+		if r.URL.Path != "/api/v1/namespaces/default/secrets" || query.Get("fieldSelector") != "type="+tlsSecret {
+			t.Errorf("unexpected request: %s", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		enc := json.NewEncoder(w)
+
+		if query.Get("watch") == "" {
+			requests = append(requests, "list")
+			lists++
+
+			var list secretList
+			if lists == 1 {
+				list.Metadata.ResourceVersion = "1"
+				list.Items = []secret{a}
+			} else {
+				list.Metadata.ResourceVersion = "10"
+				list.Items = []secret{c}
+			}
+
+			_ = enc.Encode(list)
+			return
+		}
+
+		rv := query.Get("resourceVersion")
+		requests = append(requests, "watch "+rv)
+		watches[rv]++
+
+		switch rv {
+		case "1":
+			if watches[rv] == 1 {
+				// Transient
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			var bookmark watchEvent
+			bookmark.Type = "BOOKMARK"
+			bookmark.Object.Metadata.ResourceVersion = "2"
+			_ = enc.Encode(bookmark)
+		case "2":
+			var added watchEvent
+			added.Type = "ADDED"
+			added.Object.secret = b
+			added.Object.Metadata.ResourceVersion = "3"
+			_ = enc.Encode(added)
+		case "3":
+			var gone watchEvent
+			gone.Type = "ERROR"
+			gone.Object.Code = int(errGone)
+			gone.Object.Message = "too old resource version"
+			_ = enc.Encode(gone)
+		default:
+			t.Errorf("unexpected resource version: %s", rv)
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	k := &Kubernetes{Numbered: Numbered{Nr: 1}, Api: srv.URL, Namespace: "default"}
+
+	if err := k.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	mtx.Lock()
+	lists = 0
+	requests = nil
+	mtx.Unlock()
+
+	for _, expected := range [][]string{
+		{"a.example.com"},
+		{"a.example.com", "b.example.com"},
+		{"c.example.com"},
+	} {
+		certs, _, err := k.Poll(ctx, time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if actual := sansOf(certs); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	expected := []string{"list", "watch 1", "watch 1", "watch 2", "watch 3", "list"}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("expected requests %v, got %v", expected, requests)
+	}
+}
+
+func newSecret(t *testing.T, name, san string) secret {
+	t.Helper()
+
+	cert, _ := testcerts.New(t, san, testcerts.Options{})
+	s := secret{Type: tlsSecret, Data: map[string][]byte{"tls.crt": testcerts.PEM(cert)}}
+
+	s.Metadata.Namespace = "default"
+	s.Metadata.Name = name
+	return s
+}
+
+func sansOf(certs []*x509.Certificate) []string {
+	var sans []string
+	for _, cert := range certs {
+		sans = append(sans, cert.DNSNames...)
+	}
+
+	sort.Strings(sans)
+	return sans
+}
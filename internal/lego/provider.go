@@ -0,0 +1,116 @@
+package lego
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"github.com/Al2Klimov/FUeL.go"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+type Lego struct {
+	Numbered
+
+	// Path is lego's --path, e.g. /root/.lego.
+	Path string
+}
+
+var _ Input = (*Lego)(nil)
+
+// metadata is a certificates/<domain>.json.
+type metadata struct {
+	Domain        string `json:"domain"`
+	CertUrl       string `json:"certUrl"`
+	CertStableUrl string `json:"certStableUrl"`
+}
+
+func (*Lego) Kind() string {
+	return "lego"
+}
+
+func (l *Lego) Ping(context.Context) fuel.ErrorWithStack {
+	_, err := ioutil.ReadDir(l.Path)
+	return fuel.AttachStackToError(err, 0)
+}
+
+func (l *Lego) Poll(ctx context.Context, since time.Time) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
+	return PollFiles(ctx, l, since, l.dirs, l.read)
+}
+
+func (l *Lego) read(since time.Time) ([]*x509.Certificate, bool, time.Time, fuel.ErrorWithStack) {
+	if _, err := os.Stat(l.certsDir()); err != nil {
+		if os.IsNotExist(err) {
+			ProviderLog(l).Warn("certificates directory doesn't exist, assuming only temporarily")
+			return nil, false, since, nil
+		}
+
+		return nil, false, time.Time{}, fuel.AttachStackToError(err, 0)
+	}
+
+	certFiles, err := l.certFiles()
+	if err != nil {
+		return nil, false, time.Time{}, err
+	}
+
+	return ReadLeaves(l, since, []string{l.certsDir()}, certFiles)
+}
+
+// dirs returns the lego path itself and certificates/ in it.
+func (l *Lego) dirs() []string {
+	return []string{l.Path, l.certsDir()}
+}
+
+// certFiles returns certificates/<domain>.crt of all certificates/<domain>.json.
+// Wildcard domains' file names start with "_" instead of "*".
+func (l *Lego) certFiles() ([]string, fuel.ErrorWithStack) {
+	entries, err := ioutil.ReadDir(l.certsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, fuel.AttachStackToError(err, 0)
+	}
+
+	var certFiles []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		file := path.Join(l.certsDir(), entry.Name())
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, fuel.AttachStackToError(err, 0)
+		}
+
+		var meta metadata
+		if err := json.Unmarshal(content, &meta); err != nil {
+			ProviderLog(l).WithError(err).WithField("file", file).Warn("can't parse certificate metadata")
+			continue
+		}
+
+		ProviderLog(l).WithFields(log.Fields{
+			"file": file, "domain": meta.Domain, "url": meta.CertStableUrl,
+		}).Trace("found certificate")
+
+		certFiles = append(certFiles, strings.TrimSuffix(file, ".json")+".crt")
+	}
+
+	return certFiles, nil
+}
+
+func (l *Lego) certsDir() string {
+	return path.Join(l.Path, "certificates")
+}
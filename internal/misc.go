@@ -31,10 +31,38 @@ type Input interface {
 	Poll(ctx context.Context, since time.Time) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack)
 }
 
+// HostServices maps host names to services like "_443._tcp".
+type HostServices map[string]map[string]struct{}
+
+// Discovery tells which services are actually served per host.
+type Discovery interface {
+	Provider
+
+	Discover(ctx context.Context, since time.Time) (HostServices, time.Time, fuel.ErrorWithStack)
+}
+
+// AdditiveDiscovery is a Discovery which can't tell which services its hosts don't serve,
+// e.g. a mail server knowing only its certificates' SANs. Its services are added to the otherwise assigned ones.
+type AdditiveDiscovery interface {
+	Discovery
+
+	Additive()
+}
+
+// Qualified providers share their kind and number with others, e.g. one per Traefik certificate resolver.
+// LogFields tell them apart in logs.
+type Qualified interface {
+	Provider
+
+	LogFields() log.Fields
+}
+
 type Output interface {
 	Provider
 
-	Update(ctx context.Context, del, create OutputRecordSet) fuel.ErrorWithStack
+	// Update deletes del and creates create. It returns the records of create it doesn't handle,
+	// e.g. outside its zones, so that they aren't considered written unless another output handles them.
+	Update(ctx context.Context, del, create OutputRecordSet) (skipped OutputRecordSet, err fuel.ErrorWithStack)
 }
 
 type Numbered struct {
@@ -55,7 +83,12 @@ func SetupLogging() {
 }
 
 func ProviderLog(p Provider) *log.Entry {
-	return log.WithFields(log.Fields{"kind": p.Kind(), "number": p.Number()})
+	entry := log.WithFields(log.Fields{"kind": p.Kind(), "number": p.Number()})
+	if q, ok := p.(Qualified); ok {
+		entry = entry.WithFields(q.LogFields())
+	}
+
+	return entry
 }
 
 func Unwildcard(p Provider, aRecs map[string]struct{}, orss ...*OutputRecordSet) {
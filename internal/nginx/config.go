@@ -0,0 +1,226 @@
+package nginx
+
+import (
+	"fmt"
+	"github.com/Al2Klimov/FUeL.go"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// directive is an nginx config directive like "listen 443 ssl;" or "server { ... }".
+type directive struct {
+	name  string
+	args  []string
+	block []directive
+	// file is the config file the directive is from.
+	file string
+}
+
+// config is a parsed nginx config with all includes resolved.
+type config struct {
+	directives []directive
+	// files are all config files read.
+	files []string
+	// dirs are the directories include globs look into.
+	dirs []string
+}
+
+// parseConfig parses file and everything it includes. Relative includes are resolved against prefix.
+func parseConfig(file, prefix string) (*config, fuel.ErrorWithStack) {
+	cfg := &config{}
+	directives, err := cfg.parseFile(file, prefix, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.directives = directives
+	return cfg, nil
+}
+
+func (c *config) parseFile(file, prefix string, depth int) ([]directive, fuel.ErrorWithStack) {
+	if depth > 32 {
+		return nil, fuel.AttachStackToError(fmt.Errorf("%s: includes nested too deeply", file), 0)
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fuel.AttachStackToError(err, 0)
+	}
+
+	c.files = append(c.files, file)
+
+	tokens, errTk := tokenize(string(content))
+	if errTk != nil {
+		return nil, fuel.AttachStackToError(fmt.Errorf("%s: %s", file, errTk.Error()), 0)
+	}
+
+	directives, rest, errPr := c.parseBlock(tokens, file, prefix, depth)
+	if errPr != nil {
+		return nil, errPr
+	}
+
+	if len(rest) > 0 {
+		return nil, fuel.AttachStackToError(fmt.Errorf("%s: unexpected \"}\"", file), 0)
+	}
+
+	return directives, nil
+}
+
+// parseBlock parses tokens until the end or a "}" which is left in rest.
+func (c *config) parseBlock(
+	tokens []string, file, prefix string, depth int,
+) (directives []directive, rest []string, err fuel.ErrorWithStack) {
+	var current []string
+	for len(tokens) > 0 {
+		token := tokens[0]
+		tokens = tokens[1:]
+
+		switch token {
+		case ";":
+			if len(current) < 1 {
+				continue
+			}
+
+			if current[0] == "include" && len(current) > 1 {
+				included, err := c.include(current[1], prefix, depth)
+				if err != nil {
+					return nil, nil, err
+				}
+
+				directives = append(directives, included...)
+			} else {
+				directives = append(directives, directive{name: current[0], args: current[1:], file: file})
+			}
+
+			current = nil
+		case "{":
+			if len(current) < 1 {
+				return nil, nil, fuel.AttachStackToError(fmt.Errorf("%s: unexpected \"{\"", file), 0)
+			}
+
+			block, rest, err := c.parseBlock(tokens, file, prefix, depth)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if len(rest) < 1 {
+				return nil, nil, fuel.AttachStackToError(fmt.Errorf("%s: unexpected end of file", file), 0)
+			}
+
+			directives = append(directives, directive{name: current[0], args: current[1:], block: block, file: file})
+			current = nil
+			tokens = rest[1:]
+		case "}":
+			if len(current) > 0 {
+				return nil, nil, fuel.AttachStackToError(fmt.Errorf("%s: unexpected \"}\"", file), 0)
+			}
+
+			return directives, append([]string{token}, tokens...), nil
+		default:
+			current = append(current, token)
+		}
+	}
+
+	if len(current) > 0 {
+		return nil, nil, fuel.AttachStackToError(fmt.Errorf("%s: unexpected end of file", file), 0)
+	}
+
+	return directives, nil, nil
+}
+
+// include parses all files matching pattern.
+func (c *config) include(pattern, prefix string, depth int) ([]directive, fuel.ErrorWithStack) {
+	pattern = resolve(pattern, prefix)
+	c.dirs = append(c.dirs, path.Dir(pattern))
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fuel.AttachStackToError(err, 0)
+	}
+
+	var directives []directive
+	for _, file := range files {
+		included, err := c.parseFile(file, prefix, depth+1)
+		if err != nil {
+			return nil, err
+		}
+
+		directives = append(directives, included...)
+	}
+
+	return directives, nil
+}
+
+// tokenize splits an nginx config into words, "{", "}" and ";". Quotes and comments are resolved.
+func tokenize(content string) ([]string, error) {
+	var tokens []string
+	var word strings.Builder
+	inWord := false
+
+	flush := func() {
+		if inWord {
+			tokens = append(tokens, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+
+	for i := 0; i < len(content); i++ {
+		switch ch := content[i]; ch {
+		case ' ', '\t', '\r', '\n':
+			flush()
+		case '#':
+			flush()
+
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+		case '{', '}', ';':
+			flush()
+			tokens = append(tokens, string(ch))
+		case '"', '\'':
+			inWord = true
+
+			for i++; ; i++ {
+				if i >= len(content) {
+					return nil, fmt.Errorf("unterminated %c", ch)
+				}
+
+				if content[i] == ch {
+					break
+				}
+
+				if content[i] == '\\' && i+1 < len(content) {
+					i++
+				}
+
+				word.WriteByte(content[i])
+			}
+		case '\\':
+			inWord = true
+
+			if i+1 < len(content) {
+				i++
+			}
+
+			word.WriteByte(content[i])
+		default:
+			inWord = true
+			word.WriteByte(ch)
+		}
+	}
+
+	flush()
+	return tokens, nil
+}
+
+// resolve makes file absolute relative to prefix.
+func resolve(file, prefix string) string {
+	if path.IsAbs(file) {
+		return file
+	}
+
+	return path.Join(prefix, file)
+}
@@ -0,0 +1,279 @@
+package nginx
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"github.com/Al2Klimov/FUeL.go"
+	log "github.com/sirupsen/logrus"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Nginx reads the certificates of the TLS-enabled http servers of an nginx config
+// and discovers the ports they're served on per server name.
+type Nginx struct {
+	Numbered
+
+	// Config is the main config file, e.g. /etc/nginx/nginx.conf.
+	Config string
+	// Prefix is nginx' --conf-path directory relative paths are resolved against, defaults to Config's directory.
+	Prefix string
+}
+
+var _ Input = (*Nginx)(nil)
+var _ Discovery = (*Nginx)(nil)
+
+// server is an http server block.
+type server struct {
+	names []string
+	certs []string
+	// services are like "_443._tcp". Only TLS-enabled ones are considered.
+	services map[string]struct{}
+}
+
+func (*Nginx) Kind() string {
+	return "nginx"
+}
+
+func (n *Nginx) Ping(context.Context) fuel.ErrorWithStack {
+	_, err := parseConfig(n.Config, n.prefix())
+	return err
+}
+
+func (n *Nginx) Poll(ctx context.Context, since time.Time) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
+	return PollFiles(ctx, n, since, n.dirs, n.read)
+}
+
+// Discover re-reads the config on every change inside the directories it (and its includes) reside in.
+func (n *Nginx) Discover(ctx context.Context, since time.Time) (HostServices, time.Time, fuel.ErrorWithStack) {
+	var services HostServices
+	_, asOf, err := PollFiles(ctx, n, since, n.dirs, func(since time.Time) (
+		[]*x509.Certificate, bool, time.Time, fuel.ErrorWithStack,
+	) {
+		cfg, ok := n.parse()
+		if !ok {
+			return nil, false, since, nil
+		}
+
+		mt, err := ModTime(append(append([]string(nil), cfg.files...), cfg.dirs...)...)
+		if err != nil {
+			return nil, false, time.Time{}, err
+		}
+
+		if !mt.After(since) {
+			ProviderLog(n).WithFields(log.Fields{
+				"mtime": mt, "since": since,
+			}).Trace("config files' mod times didn't change")
+			return nil, false, since, nil
+		}
+
+		services = HostServices{}
+		for _, srv := range n.servers(cfg) {
+			if len(srv.services) < 1 {
+				// E.g. a plain HTTP one which tells nothing about the TLS services of its names
+				continue
+			}
+
+			for _, name := range srv.names {
+				perHost, ok := services[name]
+				if !ok {
+					perHost = map[string]struct{}{}
+					services[name] = perHost
+				}
+
+				for svc := range srv.services {
+					perHost[svc] = struct{}{}
+				}
+			}
+		}
+
+		return nil, true, mt, nil
+	})
+
+	return services, asOf, err
+}
+
+func (n *Nginx) read(since time.Time) ([]*x509.Certificate, bool, time.Time, fuel.ErrorWithStack) {
+	cfg, ok := n.parse()
+	if !ok {
+		return nil, false, since, nil
+	}
+
+	return ReadLeaves(n, since, append(append([]string(nil), cfg.files...), cfg.dirs...), n.certFiles(cfg))
+}
+
+// dirs returns the directories of all config files, include globs and certificates.
+func (n *Nginx) dirs() []string {
+	cfg, err := parseConfig(n.Config, n.prefix())
+	if err != nil {
+		return DirsOf(n.Config)
+	}
+
+	return append(append(DirsOf(cfg.files...), cfg.dirs...), DirsOf(n.certFiles(cfg)...)...)
+}
+
+// parse parses the config. Errors are logged and assumed to be temporary (e.g. while editing).
+func (n *Nginx) parse() (*config, bool) {
+	cfg, err := parseConfig(n.Config, n.prefix())
+	if err != nil {
+		ProviderLog(n).WithError(err).Warn("can't parse config, assuming error is temporary")
+		return nil, false
+	}
+
+	return cfg, true
+}
+
+// certFiles returns the certificates of all TLS-enabled http servers.
+func (n *Nginx) certFiles(cfg *config) []string {
+	seen := map[string]struct{}{}
+	var files []string
+
+	for _, srv := range n.servers(cfg) {
+		if len(srv.services) < 1 {
+			continue
+		}
+
+		for _, cert := range srv.certs {
+			if _, ok := seen[cert]; !ok {
+				seen[cert] = struct{}{}
+				files = append(files, cert)
+			}
+		}
+	}
+
+	return files
+}
+
+// servers returns all http servers.
+func (n *Nginx) servers(cfg *config) []server {
+	var servers []server
+	for _, http := range cfg.directives {
+		if http.name != "http" {
+			continue
+		}
+
+		inherited := n.sslCertificates(http.block)
+
+		for _, srv := range http.block {
+			if srv.name != "server" {
+				continue
+			}
+
+			s := server{certs: n.sslCertificates(srv.block), services: map[string]struct{}{}}
+			if s.certs == nil {
+				s.certs = inherited
+			}
+
+			// The deprecated "ssl on;" enables TLS on all listeners.
+			sslOn := false
+			var listens [][]string
+
+			for _, d := range srv.block {
+				switch d.name {
+				case "server_name":
+					for _, name := range d.args {
+						s.names = append(s.names, serverNames(name)...)
+					}
+				case "listen":
+					listens = append(listens, d.args)
+				case "ssl":
+					sslOn = len(d.args) > 0 && d.args[0] == "on"
+				}
+			}
+
+			for _, listen := range listens {
+				if port, ssl, quic, ok := parseListen(listen); ok {
+					if ssl || sslOn {
+						s.services[fmt.Sprintf("_%d._tcp", port)] = struct{}{}
+					}
+
+					if quic {
+						s.services[fmt.Sprintf("_%d._udp", port)] = struct{}{}
+					}
+				}
+			}
+
+			servers = append(servers, s)
+		}
+	}
+
+	return servers
+}
+
+// sslCertificates returns the ssl_certificate files among directives.
+// Ones containing variables can't be resolved and are skipped.
+func (n *Nginx) sslCertificates(directives []directive) []string {
+	var certs []string
+	for _, d := range directives {
+		if d.name == "ssl_certificate" && len(d.args) > 0 && !strings.Contains(d.args[0], "$") {
+			certs = append(certs, resolve(d.args[0], n.prefix()))
+		}
+	}
+
+	return certs
+}
+
+func (n *Nginx) prefix() string {
+	if n.Prefix != "" {
+		return n.Prefix
+	}
+
+	return path.Dir(n.Config)
+}
+
+// parseListen parses the arguments of a listen directive, e.g. "[::]:443 ssl http2".
+func parseListen(args []string) (port uint16, ssl bool, quic bool, ok bool) {
+	if len(args) < 1 || strings.HasPrefix(args[0], "unix:") {
+		return
+	}
+
+	address := args[0]
+	portStr := "80"
+
+	if strings.HasPrefix(address, "[") {
+		if end := strings.Index(address, "]:"); end >= 0 {
+			portStr = address[end+2:]
+		}
+	} else if colon := strings.LastIndex(address, ":"); colon >= 0 {
+		portStr = address[colon+1:]
+	} else if _, err := strconv.ParseUint(address, 10, 16); err == nil {
+		portStr = address
+	}
+
+	p, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return
+	}
+
+	for _, param := range args[1:] {
+		switch param {
+		case "ssl":
+			ssl = true
+		case "quic":
+			quic = true
+		}
+	}
+
+	return uint16(p), ssl, quic, true
+}
+
+// serverNames translates a server_name argument into DNS names.
+// Regular expressions, trailing wildcards and the catch-all "_" are skipped.
+func serverNames(name string) []string {
+	name = strings.ToLower(name)
+
+	switch {
+	case name == "" || name == "_" || strings.HasPrefix(name, "~") || strings.HasSuffix(name, ".*"):
+		return nil
+	case strings.Contains(name, "$"):
+		return nil
+	case strings.HasPrefix(name, "."):
+		return []string{name[1:], "*" + name}
+	default:
+		return []string{name}
+	}
+}
@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+)
+
+var errNoCerts = errors.New("PEM doesn't contain any certificates")
+
+// ParseLeaf parses the first certificate of a PEM chain, other blocks (e.g. keys) are skipped.
+func ParseLeaf(chain []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		if block, chain = pem.Decode(chain); block == nil {
+			return nil, errNoCerts
+		}
+
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+// ParseLeaves parses all non-CA certificates of a PEM bundle, other blocks (e.g. keys) are skipped.
+func ParseLeaves(bundle []byte) ([]*x509.Certificate, error) {
+	var leaves []*x509.Certificate
+	for {
+		var block *pem.Block
+		if block, bundle = pem.Decode(bundle); block == nil {
+			return leaves, nil
+		}
+
+		if block.Type == "CERTIFICATE" {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+
+			if !cert.IsCA {
+				leaves = append(leaves, cert)
+			}
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package internal
+
+import (
+	"context"
+	"crypto/x509"
+	"github.com/Al2Klimov/FUeL.go"
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// FileWatching configures PollFiles for all inputs.
+var FileWatching struct {
+	// PollInterval makes PollFiles also look for changes by itself, e.g. on NFS where fsnotify doesn't work.
+	// Disabled if 0.
+	PollInterval time.Duration
+	// ReReadInterval makes PollFiles re-read everything regardless of mod times as a safety net. Disabled if 0.
+	ReReadInterval time.Duration
+}
+
+// FileReader reads an input's files unless they didn't change since the given time.
+type FileReader func(since time.Time) (certs []*x509.Certificate, changed bool, asOf time.Time, err fuel.ErrorWithStack)
+
+// PollFiles implements Input#Poll for inputs based on local files
+// by calling read once and then on every change inside the directories returned by dirs
+// (and as configured via FileWatching).
+func PollFiles(
+	ctx context.Context, in Input, since time.Time, dirs func() []string, read FileReader,
+) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
+	{
+		certs, ok, mt, err := read(since)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		if ok {
+			return certs, mt, nil
+		}
+
+		since = mt
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, time.Time{}, fuel.AttachStackToError(err, 0)
+	}
+	defer func() { _ = watcher.Close() }()
+
+	watched := map[string]string{}
+	if err := watchDirs(in, watcher, watched, dirs()); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var poll, reRead <-chan time.Time
+
+	if FileWatching.PollInterval > 0 {
+		ticker := time.NewTicker(FileWatching.PollInterval)
+		defer ticker.Stop()
+
+		poll = ticker.C
+	}
+
+	if FileWatching.ReReadInterval > 0 {
+		ticker := time.NewTicker(FileWatching.ReReadInterval)
+		defer ticker.Stop()
+
+		reRead = ticker.C
+	}
+
+	for {
+		readSince := since
+
+		select {
+		case <-ctx.Done():
+			return nil, time.Time{}, fuel.AttachStackToError(ctx.Err(), 0)
+		case err := <-watcher.Errors:
+			if err == fsnotify.ErrEventOverflow {
+				ProviderLog(in).Warn("FS watch queue overflow, events may have been lost")
+			} else {
+				ProviderLog(in).WithError(err).Warn("FS watch error")
+			}
+		case event := <-watcher.Events:
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				// If a watched directory itself is replaced, its watch is gone. Re-add it.
+				delete(watched, path.Clean(event.Name))
+			}
+		case <-poll:
+			ProviderLog(in).Trace("polling for changes")
+		case <-reRead:
+			ProviderLog(in).Debug("re-reading regardless of mod times")
+			readSince = time.Time{}
+		}
+
+		if err := watchDirs(in, watcher, watched, dirs()); err != nil {
+			return nil, time.Time{}, err
+		}
+
+		certs, ok, mt, err := read(readSince)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		if ok {
+			return certs, mt, nil
+		}
+
+		if readSince == since {
+			since = mt
+		}
+	}
+}
+
+// watchDirs makes watcher watch exactly dirs, except the ones which don't exist (yet).
+// watched maps the directories being watched to their symlink targets (if any)
+// as a swapped symlink (e.g. Kubernetes' ..data) must be watched anew.
+func watchDirs(in Input, watcher *fsnotify.Watcher, watched map[string]string, dirs []string) fuel.ErrorWithStack {
+	wanted := make(map[string]string, len(dirs))
+	for _, dir := range dirs {
+		dir = path.Clean(dir)
+		target, _ := filepath.EvalSymlinks(dir)
+		wanted[dir] = target
+	}
+
+	for dir, target := range watched {
+		if wt, ok := wanted[dir]; !ok || wt != target {
+			_ = watcher.Remove(dir)
+			delete(watched, dir)
+		}
+	}
+
+	for dir, target := range wanted {
+		if _, ok := watched[dir]; ok {
+			continue
+		}
+
+		if err := watcher.Add(dir); err != nil {
+			if os.IsNotExist(err) {
+				ProviderLog(in).WithField("dir", dir).Debug("directory to watch doesn't exist, yet")
+				continue
+			}
+
+			return fuel.AttachStackToError(err, 0)
+		}
+
+		watched[dir] = target
+	}
+
+	return nil
+}
+
+// ReadLeaves implements FileReader by reading the leaves of the given PEM files
+// unless neither they nor deps (e.g. directories or config files) changed since the given time.
+func ReadLeaves(in Input, since time.Time, deps, files []string) ([]*x509.Certificate, bool, time.Time, fuel.ErrorWithStack) {
+	mt, err := ModTime(append(append([]string(nil), deps...), files...)...)
+	if err != nil {
+		return nil, false, time.Time{}, err
+	}
+
+	if !mt.After(since) {
+		ProviderLog(in).WithFields(log.Fields{
+			"mtime": mt, "since": since,
+		}).Trace("certificates' mod times didn't change")
+		return nil, false, since, nil
+	}
+
+	var certs []*x509.Certificate
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				ProviderLog(in).WithField("file", file).Debug("certificate doesn't exist, skipping")
+				continue
+			}
+
+			return nil, false, time.Time{}, fuel.AttachStackToError(err, 0)
+		}
+
+		leaf, err := ParseLeaf(content)
+		if err != nil {
+			ProviderLog(in).WithError(err).WithField("file", file).Warn("can't parse PEM")
+			continue
+		}
+
+		certs = append(certs, leaf)
+	}
+
+	return certs, true, mt, nil
+}
+
+// DirsOf returns the directories containing the given files and the targets of the ones being symlinks.
+func DirsOf(files ...string) []string {
+	dirs := make([]string, 0, len(files))
+	for _, file := range files {
+		dirs = append(dirs, path.Dir(file))
+
+		if target, err := filepath.EvalSymlinks(file); err == nil {
+			dirs = append(dirs, path.Dir(target))
+		}
+	}
+
+	return dirs
+}
+
+// ModTime returns the latest mod (or change) time of the given files (or directories) and symlinks among them.
+// Missing ones are ignored.
+func ModTime(files ...string) (time.Time, fuel.ErrorWithStack) {
+	var mt time.Time
+	for _, file := range files {
+		// Symlinks (e.g. into certbot's archive/) may change independently of their targets.
+		for _, stat := range [2]func(string) (os.FileInfo, error){os.Lstat, os.Stat} {
+			if err := updateModTime(&mt, stat, file); err != nil {
+				return time.Time{}, err
+			}
+		}
+	}
+
+	return mt, nil
+}
+
+// updateModTime raises mt to the mod (or change) time of file if the latter is newer.
+func updateModTime(mt *time.Time, stat func(string) (os.FileInfo, error), file string) fuel.ErrorWithStack {
+	st, err := stat(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return fuel.AttachStackToError(err, 0)
+	}
+
+	// Files moved into place (atomically) may have an older mod time than the one they replace.
+	for _, smt := range [2]time.Time{st.ModTime(), changeTime(st)} {
+		if smt.After(*mt) {
+			*mt = smt
+		}
+	}
+
+	return nil
+}
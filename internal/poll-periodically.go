@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"github.com/Al2Klimov/FUeL.go"
+	"os"
+	"time"
+)
+
+// PollPeriodically implements Input#Poll for inputs which can't notify about changes
+// by calling read every interval (initially at once) until its result differs from the last one.
+// The hash of the latter is stored in lastHash. Any signal received from signals (may be nil) triggers read early.
+func PollPeriodically(
+	ctx context.Context, in Input, since time.Time, interval time.Duration, lastHash *[sha256.Size]byte,
+	signals <-chan os.Signal,
+	read func(context.Context) ([]*x509.Certificate, fuel.ErrorWithStack),
+) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
+	for {
+		if since != (time.Time{}) {
+			timer := time.NewTimer(time.Until(since.Add(interval)))
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, time.Time{}, fuel.AttachStackToError(ctx.Err(), 0)
+			case <-timer.C:
+			case sig := <-signals:
+				timer.Stop()
+				ProviderLog(in).WithField("signal", sig).Debug("got signal, reading early")
+			}
+		}
+
+		since = time.Now()
+
+		certs, err := read(ctx)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		hash := sha256.New()
+		for _, cert := range certs {
+			_, _ = hash.Write(cert.Raw)
+		}
+
+		var sum [sha256.Size]byte
+		if copy(sum[:], hash.Sum(nil)); sum == *lastHash {
+			ProviderLog(in).Trace("certificates didn't change")
+			continue
+		}
+
+		*lastHash = sum
+		return certs, since, nil
+	}
+}
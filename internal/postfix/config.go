@@ -0,0 +1,189 @@
+package postfix
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/Al2Klimov/FUeL.go"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// wellKnownPorts are the ones of master.cf service names in /etc/services relevant for mail.
+var wellKnownPorts = map[string]uint16{"smtp": 25, "submission": 587, "submissions": 465, "smtps": 465}
+
+// service is an inet service of master.cf.
+type service struct {
+	port    uint16
+	command string
+	// overrides are the -o name=value options.
+	overrides map[string]string
+}
+
+// readLogicalLines reads file's non-comment lines with continuation lines (starting with whitespace) joined.
+// A missing file counts as empty.
+func readLogicalLines(file string) ([]string, fuel.ErrorWithStack) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, fuel.AttachStackToError(err, 0)
+	}
+
+	var lines []string
+	physical := bufio.NewScanner(bytes.NewReader(content))
+
+	for physical.Scan() {
+		line := physical.Text()
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#"):
+		case line[0] == ' ' || line[0] == '\t':
+			if len(lines) > 0 {
+				lines[len(lines)-1] += " " + trimmed
+			}
+		default:
+			lines = append(lines, trimmed)
+		}
+	}
+
+	return lines, fuel.AttachStackToError(physical.Err(), 0)
+}
+
+// parseMainCf parses main.cf's "name = value" lines.
+func parseMainCf(file string) (map[string]string, fuel.ErrorWithStack) {
+	lines, err := readLogicalLines(file)
+	if err != nil {
+		return nil, err
+	}
+
+	params := map[string]string{}
+	for _, line := range lines {
+		if eq := strings.Index(line, "="); eq > 0 {
+			params[strings.TrimSpace(line[:eq])] = strings.TrimSpace(line[eq+1:])
+		}
+	}
+
+	return params, nil
+}
+
+// parseMasterCf parses master.cf's inet services, i.e. lines like "[host:]port inet n - y - - smtpd -o name=value".
+func parseMasterCf(file string) ([]service, fuel.ErrorWithStack) {
+	lines, err := readLogicalLines(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var services []service
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 8 || fields[1] != "inet" {
+			continue
+		}
+
+		name := fields[0]
+		if colon := strings.LastIndex(name, ":"); colon >= 0 {
+			name = name[colon+1:]
+		}
+
+		port, ok := wellKnownPorts[name]
+		if !ok {
+			p, err := strconv.ParseUint(name, 10, 16)
+			if err != nil {
+				continue
+			}
+
+			port = uint16(p)
+		}
+
+		svc := service{port: port, command: fields[7], overrides: map[string]string{}}
+		args := fields[8:]
+
+		for i := 0; i < len(args); i++ {
+			option := args[i]
+			if option == "-o" && i+1 < len(args) {
+				i++
+				option = args[i]
+			} else if strings.HasPrefix(option, "-o") {
+				option = option[2:]
+			} else {
+				continue
+			}
+
+			if eq := strings.Index(option, "="); eq > 0 {
+				svc.overrides[option[:eq]] = strings.Trim(option[eq+1:], "{} ")
+			}
+		}
+
+		services = append(services, svc)
+	}
+
+	return services, nil
+}
+
+// expand resolves $name, ${name}, $(name), ${name?value} and ${name:value} in value.
+func expand(value string, params map[string]string, depth int) string {
+	if depth > 16 || !strings.Contains(value, "$") {
+		return value
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 >= len(value) {
+			out.WriteByte(value[i])
+			continue
+		}
+
+		var ref string
+		switch open := value[i+1]; open {
+		case '{', '(':
+			closing := map[byte]byte{'{': '}', '(': ')'}[open]
+			end := strings.IndexByte(value[i+2:], closing)
+			if end < 0 {
+				out.WriteString(value[i:])
+				return out.String()
+			}
+
+			ref = value[i+2 : i+2+end]
+			i += end + 2
+		case '$':
+			out.WriteByte('$')
+			i++
+			continue
+		default:
+			end := i + 1
+			for end < len(value) && (value[end] == '_' || isAlnum(value[end])) {
+				end++
+			}
+
+			ref = value[i+1 : end]
+			i = end - 1
+		}
+
+		if q := strings.IndexAny(ref, "?:"); q >= 0 {
+			set := expand(params[ref[:q]], params, depth+1) != ""
+			if (ref[q] == '?') == set {
+				out.WriteString(expand(ref[q+1:], params, depth+1))
+			}
+		} else {
+			out.WriteString(expand(params[ref], params, depth+1))
+		}
+	}
+
+	return out.String()
+}
+
+func isAlnum(ch byte) bool {
+	return ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9'
+}
+
+// splitList splits a Postfix list value separated by whitespace and/or commas.
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
@@ -0,0 +1,220 @@
+package postfix
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"github.com/Al2Klimov/FUeL.go"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Postfix reads the certificates of the TLS-enabled smtpd (and postscreen) inet services of a Postfix config
+// and discovers these services' ports for all SANs of the respective certificates (in addition to their other ones).
+type Postfix struct {
+	Numbered
+
+	// ConfigDir contains main.cf and master.cf, e.g. /etc/postfix.
+	ConfigDir string
+}
+
+var _ Input = (*Postfix)(nil)
+var _ AdditiveDiscovery = (*Postfix)(nil)
+
+func (*Postfix) Kind() string {
+	return "Postfix"
+}
+
+// Additive as e.g. a www SAN of the mail certificate may also be served by a web server.
+func (*Postfix) Additive() {
+}
+
+func (p *Postfix) Ping(context.Context) fuel.ErrorWithStack {
+	_, err := ioutil.ReadFile(p.mainCf())
+	return fuel.AttachStackToError(err, 0)
+}
+
+func (p *Postfix) Poll(ctx context.Context, since time.Time) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
+	return PollFiles(ctx, p, since, p.dirs, func(since time.Time) (
+		[]*x509.Certificate, bool, time.Time, fuel.ErrorWithStack,
+	) {
+		certs, _, changed, asOf, err := p.read(since)
+		return certs, changed, asOf, err
+	})
+}
+
+func (p *Postfix) Discover(ctx context.Context, since time.Time) (HostServices, time.Time, fuel.ErrorWithStack) {
+	var services HostServices
+	_, asOf, err := PollFiles(ctx, p, since, p.dirs, func(since time.Time) (
+		[]*x509.Certificate, bool, time.Time, fuel.ErrorWithStack,
+	) {
+		_, current, changed, asOf, err := p.read(since)
+		services = current
+		return nil, changed, asOf, err
+	})
+
+	return services, asOf, err
+}
+
+// read returns the leaves of all TLS-enabled services and these services per SAN
+// unless neither the config nor the certificates changed since the given time.
+func (p *Postfix) read(since time.Time) (
+	certs []*x509.Certificate, services HostServices, changed bool, asOf time.Time, err fuel.ErrorWithStack,
+) {
+	certFiles, servicesByFile, err := p.parse()
+	if err != nil {
+		return nil, nil, false, time.Time{}, err
+	}
+
+	mt, err := ModTime(append([]string{p.mainCf(), p.masterCf()}, certFiles...)...)
+	if err != nil {
+		return nil, nil, false, time.Time{}, err
+	}
+
+	if !mt.After(since) {
+		ProviderLog(p).WithFields(log.Fields{
+			"mtime": mt, "since": since,
+		}).Trace("config's and certificates' mod times didn't change")
+		return nil, nil, false, since, nil
+	}
+
+	services = HostServices{}
+	for _, file := range certFiles {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				ProviderLog(p).WithField("file", file).Debug("certificate doesn't exist, skipping")
+				continue
+			}
+
+			return nil, nil, false, time.Time{}, fuel.AttachStackToError(err, 0)
+		}
+
+		// Chain files may also contain the key and intermediates.
+		leaves, err := ParseLeaves(content)
+		if err != nil {
+			ProviderLog(p).WithError(err).WithField("file", file).Warn("can't parse PEM")
+			continue
+		}
+
+		for _, leaf := range leaves {
+			certs = append(certs, leaf)
+
+			for _, san := range leaf.DNSNames {
+				san = strings.ToLower(san)
+				perHost, ok := services[san]
+				if !ok {
+					perHost = map[string]struct{}{}
+					services[san] = perHost
+				}
+
+				for svc := range servicesByFile[file] {
+					perHost[svc] = struct{}{}
+				}
+			}
+		}
+	}
+
+	return certs, services, true, mt, nil
+}
+
+// parse returns the certificate files of all TLS-enabled services and these services per file.
+func (p *Postfix) parse() ([]string, map[string]map[string]struct{}, fuel.ErrorWithStack) {
+	main, err := parseMainCf(p.mainCf())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, ok := main["config_directory"]; !ok {
+		main["config_directory"] = p.ConfigDir
+	}
+
+	master, err := parseMasterCf(p.masterCf())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var certFiles []string
+	servicesByFile := map[string]map[string]struct{}{}
+
+	for _, svc := range master {
+		if svc.command != "smtpd" && svc.command != "postscreen" {
+			continue
+		}
+
+		params := make(map[string]string, len(main)+len(svc.overrides))
+		for k, v := range main {
+			params[k] = v
+		}
+
+		for k, v := range svc.overrides {
+			params[k] = v
+		}
+
+		get := func(name string) string {
+			return strings.ToLower(expand(params[name], params, 0))
+		}
+
+		level := get("smtpd_tls_security_level")
+		if svc.command == "postscreen" {
+			if _, ok := params["postscreen_tls_security_level"]; ok {
+				level = get("postscreen_tls_security_level")
+			}
+		}
+
+		if level != "may" && level != "encrypt" && get("smtpd_use_tls") != "yes" &&
+			get("smtpd_enforce_tls") != "yes" && get("smtpd_tls_wrappermode") != "yes" {
+			continue
+		}
+
+		// smtpd_tls_chain_files takes precedence over the legacy parameters.
+		files := splitList(expand(params["smtpd_tls_chain_files"], params, 0))
+		if len(files) < 1 {
+			for _, param := range []string{"smtpd_tls_cert_file", "smtpd_tls_eccert_file", "smtpd_tls_dcert_file"} {
+				if file := expand(params[param], params, 0); file != "" && file != "none" {
+					files = append(files, file)
+				}
+			}
+		}
+
+		for _, file := range files {
+			if !path.IsAbs(file) {
+				file = path.Join(p.ConfigDir, file)
+			}
+
+			perFile, ok := servicesByFile[file]
+			if !ok {
+				perFile = map[string]struct{}{}
+				servicesByFile[file] = perFile
+				certFiles = append(certFiles, file)
+			}
+
+			perFile[fmt.Sprintf("_%d._tcp", svc.port)] = struct{}{}
+		}
+	}
+
+	return certFiles, servicesByFile, nil
+}
+
+// dirs returns ConfigDir and the directories of all certificates.
+func (p *Postfix) dirs() []string {
+	certFiles, _, err := p.parse()
+	if err != nil {
+		ProviderLog(p).WithError(err).Warn("can't parse config")
+	}
+
+	return append([]string{p.ConfigDir}, DirsOf(certFiles...)...)
+}
+
+func (p *Postfix) mainCf() string {
+	return path.Join(p.ConfigDir, "main.cf")
+}
+
+func (p *Postfix) masterCf() string {
+	return path.Join(p.ConfigDir, "master.cf")
+}
@@ -0,0 +1,59 @@
+package rfc2136
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Al2Klimov/FUeL.go"
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+type rcode int
+
+var _ error = rcode(0)
+
+func (rc rcode) Error() string {
+	return fmt.Sprintf("DNS response code: %s", dns.RcodeToString[int(rc)])
+}
+
+// refused tells whether err is a response code of a server which isn't authoritative or won't let us write.
+// The client reports NOTAUTH as dns.ErrAuth, i.e. like a bad TSIG key, which Ping already checked.
+func refused(err error) bool {
+	var rc rcode
+	return errors.Is(err, dns.ErrAuth) || errors.As(err, &rc) && (rc == dns.RcodeRefused || rc == dns.RcodeNotAuth)
+}
+
+// exchange signs and sends msg via TCP (UPDATEs may be large) and expects one of the given response codes.
+func (r *Rfc2136) exchange(ctx context.Context, msg *dns.Msg, rcodes ...int) (*dns.Msg, fuel.ErrorWithStack) {
+	keyName := strings.ToLower(dns.Fqdn(r.TsigName))
+
+	r.once.Do(func() {
+		r.client = &dns.Client{Net: "tcp", TsigSecret: map[string]string{keyName: r.TsigSecret}}
+	})
+
+	msg.SetTsig(keyName, dns.Fqdn(strings.ToLower(r.TsigAlgorithm)), 300, time.Now().Unix())
+
+	resp, _, err := r.client.ExchangeContext(ctx, msg, r.Server)
+	logger := ProviderLog(r).WithFields(log.Fields{
+		"server": r.Server, "opcode": dns.OpcodeToString[msg.Opcode], "name": msg.Question[0].Name,
+	})
+
+	if err != nil {
+		logger.WithError(err).Debug("performed DNS request")
+		return nil, fuel.AttachStackToError(err, 0)
+	}
+
+	logger.WithField("rcode", dns.RcodeToString[resp.Rcode]).Debug("performed DNS request")
+
+	for _, rc := range rcodes {
+		if resp.Rcode == rc {
+			return resp, nil
+		}
+	}
+
+	return nil, fuel.AttachStackToError(rcode(resp.Rcode), 0)
+}
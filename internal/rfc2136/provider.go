@@ -0,0 +1,292 @@
+package rfc2136
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"encoding/hex"
+	"github.com/Al2Klimov/FUeL.go"
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+)
+
+// maxRrsPerUpdate limits the size of UPDATE messages.
+const maxRrsPerUpdate = 250
+
+// Rfc2136 writes records via DNS UPDATE (RFC 2136) signed with TSIG (RFC 8945).
+type Rfc2136 struct {
+	Numbered
+
+	// Server is the primary's host:port, e.g. ns1.example.com:53.
+	Server string
+	// Zones are discovered via SOA lookups if empty.
+	Zones []string
+	// TsigName is the key name, e.g. tlsautomate.
+	TsigName string
+	// TsigAlgorithm is hmac-sha256 or hmac-sha512.
+	TsigAlgorithm string
+	// TsigSecret is base64.
+	TsigSecret string
+
+	once   sync.Once
+	client *dns.Client
+	// accepting are the zones the server accepted an empty UPDATE for.
+	accepting map[string]struct{}
+	// discovered are the zones of names, once discovered.
+	discovered map[string]string
+}
+
+var _ Output = (*Rfc2136)(nil)
+
+func (*Rfc2136) Kind() string {
+	return "RFC 2136"
+}
+
+// Ping sends an empty UPDATE for every zone which the server has to accept.
+// If the zones are to be discovered, it only checks whether the server accepts the TSIG key
+// and Update checks every newly discovered zone the same way before changing anything.
+func (r *Rfc2136) Ping(ctx context.Context) fuel.ErrorWithStack {
+	if len(r.Zones) < 1 {
+		query := &dns.Msg{}
+		query.SetQuestion(".", dns.TypeSOA)
+
+		// Not being authoritative for . is fine, a bad key is not.
+		_, err := r.exchange(ctx, query, dns.RcodeSuccess, dns.RcodeRefused, dns.RcodeNameError)
+		return err
+	}
+
+	for _, zone := range r.Zones {
+		if err := r.checkZone(ctx, dns.Fqdn(zone)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Update deletes and inserts the records zone by zone. Records outside the configured zones are skipped.
+// So are the ones outside the discovered zones and inside discovered ones the server doesn't accept UPDATEs for,
+// e.g. foreign ones seen by a recursive server.
+func (r *Rfc2136) Update(ctx context.Context, del, create OutputRecordSet) (OutputRecordSet, fuel.ErrorWithStack) {
+	var names []string
+	for _, ors := range [2]OutputRecordSet{del, create} {
+		for or := range ors {
+			names = append(names, or.Service)
+		}
+	}
+
+	zoneOf, err := r.zonesOf(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+
+	accepted := map[string]bool{}
+	for name, zone := range zoneOf {
+		ok, checked := accepted[zone]
+		if !checked {
+			if err := r.checkZone(ctx, zone); err == nil {
+				ok = true
+			} else if len(r.Zones) > 0 || !refused(err) {
+				return nil, err
+			} else {
+				ProviderLog(r).WithError(err).WithField("zone", zone).
+					Warn("server doesn't accept UPDATEs for discovered zone, skipping its records")
+			}
+
+			accepted[zone] = ok
+		}
+
+		if !ok {
+			delete(zoneOf, name)
+		}
+	}
+
+	// [0] are to delete, [1] to insert
+	byZone := map[string]*[2][]dns.RR{}
+	skipped := OutputRecordSet{}
+
+	for i, ors := range [2]OutputRecordSet{del, create} {
+		for or := range ors {
+			zone, ok := zoneOf[or.Service]
+			if !ok {
+				ProviderLog(r).WithField("service", or.Service).Debug("record belongs to none of the zones, skipping")
+
+				if i == 1 {
+					skipped[or] = struct{}{}
+				}
+
+				continue
+			}
+
+			rrs, ok := byZone[zone]
+			if !ok {
+				rrs = &[2][]dns.RR{}
+				byZone[zone] = rrs
+			}
+
+			rrs[i] = append(rrs[i], toTlsa(or))
+		}
+	}
+
+	for zone, rrs := range byZone {
+		// Deletions first, in case an RR is updated in place (e.g. its TTL)
+		var batch [2][]dns.RR
+		for i := range rrs {
+			for _, rr := range rrs[i] {
+				batch[i] = append(batch[i], rr)
+
+				if len(batch[0])+len(batch[1]) >= maxRrsPerUpdate {
+					if err := r.update(ctx, zone, batch); err != nil {
+						return nil, err
+					}
+
+					batch = [2][]dns.RR{}
+				}
+			}
+		}
+
+		if len(batch[0])+len(batch[1]) > 0 {
+			if err := r.update(ctx, zone, batch); err != nil {
+				return nil, err
+			}
+		}
+
+		ProviderLog(r).WithFields(log.Fields{
+			"zone": zone, "deleted": len(rrs[0]), "inserted": len(rrs[1]),
+		}).Debug("updated zone")
+	}
+
+	return skipped, nil
+}
+
+// checkZone sends an empty UPDATE for zone, once, which the server has to accept.
+func (r *Rfc2136) checkZone(ctx context.Context, zone string) fuel.ErrorWithStack {
+	if _, ok := r.accepting[zone]; ok {
+		return nil
+	}
+
+	update := &dns.Msg{}
+	update.SetUpdate(zone)
+
+	if _, err := r.exchange(ctx, update, dns.RcodeSuccess); err != nil {
+		return err
+	}
+
+	if r.accepting == nil {
+		r.accepting = map[string]struct{}{}
+	}
+
+	r.accepting[zone] = struct{}{}
+	return nil
+}
+
+// update deletes rrs[0] and inserts rrs[1] in the given zone.
+func (r *Rfc2136) update(ctx context.Context, zone string, rrs [2][]dns.RR) fuel.ErrorWithStack {
+	update := &dns.Msg{}
+	update.SetUpdate(zone)
+
+	if len(rrs[0]) > 0 {
+		update.Remove(rrs[0])
+	}
+
+	if len(rrs[1]) > 0 {
+		update.Insert(rrs[1])
+	}
+
+	_, err := r.exchange(ctx, update, dns.RcodeSuccess)
+	return err
+}
+
+// zonesOf maps the given names to their configured or discovered zones, if any.
+func (r *Rfc2136) zonesOf(ctx context.Context, names []string) (map[string]string, fuel.ErrorWithStack) {
+	zoneOf := make(map[string]string, len(names))
+
+	for _, name := range names {
+		if _, ok := zoneOf[name]; ok {
+			continue
+		}
+
+		fqdn := dns.Fqdn(name)
+
+		if len(r.Zones) > 0 {
+			// The most specific one
+			longest := ""
+			for _, zone := range r.Zones {
+				if zone := dns.Fqdn(zone); dns.IsSubDomain(zone, fqdn) && len(zone) > len(longest) {
+					longest = zone
+				}
+			}
+
+			if longest != "" {
+				zoneOf[name] = longest
+			}
+
+			continue
+		}
+
+		zone, ok := r.discovered[fqdn]
+		if !ok {
+			var err fuel.ErrorWithStack
+			if zone, err = r.lookupZone(ctx, fqdn); err != nil {
+				return nil, err
+			}
+
+			// Names outside any zone are looked up again next time, in case a zone appears.
+			if zone != "" {
+				if r.discovered == nil {
+					r.discovered = map[string]string{}
+				}
+
+				r.discovered[fqdn] = zone
+			}
+		}
+
+		if zone != "" {
+			zoneOf[name] = zone
+		}
+	}
+
+	return zoneOf, nil
+}
+
+// lookupZone asks the server for the SOA of name which is either in the answer or in the authority section.
+// It returns "" if the server doesn't know one or refuses to tell, i.e. if name is none of its zones' business.
+func (r *Rfc2136) lookupZone(ctx context.Context, name string) (string, fuel.ErrorWithStack) {
+	query := &dns.Msg{}
+	query.SetQuestion(name, dns.TypeSOA)
+
+	resp, err := r.exchange(ctx, query, dns.RcodeSuccess, dns.RcodeNameError)
+	if err != nil {
+		if refused(err) {
+			ProviderLog(r).WithError(err).WithField("name", name).Trace("server refused to tell zone")
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	for _, section := range [2][]dns.RR{resp.Answer, resp.Ns} {
+		for _, rr := range section {
+			if soa, ok := rr.(*dns.SOA); ok && dns.IsSubDomain(soa.Hdr.Name, name) {
+				ProviderLog(r).WithFields(log.Fields{"name": name, "zone": soa.Hdr.Name}).Trace("discovered zone")
+				return soa.Hdr.Name, nil
+			}
+		}
+	}
+
+	ProviderLog(r).WithField("name", name).Trace("no SOA found")
+	return "", nil
+}
+
+func toTlsa(or OutputRecord) *dns.TLSA {
+	return &dns.TLSA{
+		Hdr: dns.RR_Header{
+			Name: dns.Fqdn(or.Service), Rrtype: dns.TypeTLSA, Class: dns.ClassINET, Ttl: or.Ttl,
+		},
+		Usage:        or.CertUsage,
+		Selector:     or.Selector,
+		MatchingType: or.MatchType,
+		Certificate:  strings.ToUpper(hex.EncodeToString([]byte(or.CertSpec))),
+	}
+}
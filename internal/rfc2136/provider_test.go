@@ -0,0 +1,284 @@
+package rfc2136
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"github.com/miekg/dns"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+const secret = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0cw=="
+
+// standIn is authoritative for example.com and accepts UPDATEs for it signed with the key tlsautomate.
+// Like a recursive server, it also tells example.net's SOA. It refuses to tell about other names.
+type standIn struct {
+	sync.Mutex
+
+	soa, foreignSoa dns.RR
+	// tlsa are the TLSA RRs by name and data.
+	tlsa map[[2]string]*dns.TLSA
+	// algorithms are the TSIG algorithms of the UPDATEs.
+	algorithms map[string]struct{}
+	// soaQueries count the SOA queries by name.
+	soaQueries map[string]int
+}
+
+func (s *standIn) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	s.Lock()
+	defer s.Unlock()
+
+	resp := &dns.Msg{}
+	resp.SetReply(req)
+
+	tsig := req.IsTsig()
+	if tsig == nil || w.TsigStatus() != nil {
+		resp.Rcode = dns.RcodeNotAuth
+		_ = w.WriteMsg(resp)
+		return
+	}
+
+	if req.Opcode == dns.OpcodeQuery {
+		s.soaQueries[req.Question[0].Name]++
+	}
+
+	switch name := req.Question[0].Name; {
+	case req.Opcode == dns.OpcodeUpdate && name != "example.com.":
+		resp.Rcode = dns.RcodeNotAuth
+	case req.Opcode == dns.OpcodeUpdate:
+		s.algorithms[tsig.Algorithm] = struct{}{}
+
+		for _, rr := range req.Ns {
+			tlsa := rr.(*dns.TLSA)
+			key := [2]string{tlsa.Hdr.Name, tlsa.Certificate}
+
+			if tlsa.Hdr.Class == dns.ClassNONE {
+				delete(s.tlsa, key)
+			} else {
+				s.tlsa[key] = tlsa
+			}
+		}
+	case name == "example.com.":
+		resp.Authoritative = true
+		resp.Answer = []dns.RR{s.soa}
+	case dns.IsSubDomain("example.com.", name):
+		resp.Authoritative = true
+		resp.Ns = []dns.RR{s.soa}
+	case dns.IsSubDomain("example.net.", name):
+		resp.Ns = []dns.RR{s.foreignSoa}
+	default:
+		resp.Rcode = dns.RcodeRefused
+	}
+
+	resp.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix())
+	_ = w.WriteMsg(resp)
+}
+
+func (s *standIn) expect(t *testing.T, names ...string) {
+	t.Helper()
+
+	s.Lock()
+	defer s.Unlock()
+
+	actual := map[string]struct{}{}
+	for key := range s.tlsa {
+		actual[key[0]] = struct{}{}
+	}
+
+	if len(actual) != len(names) {
+		t.Errorf("expected TLSA RRs for %v, got %v", names, actual)
+		return
+	}
+
+	for _, name := range names {
+		if _, ok := actual[name]; !ok {
+			t.Errorf("expected TLSA RRs for %v, got %v", names, actual)
+			return
+		}
+	}
+}
+
+func TestRfc2136_Discovered(t *testing.T) {
+	srv := serve(t)
+	r := &Rfc2136{
+		Numbered: Numbered{Nr: 1}, Server: srv.addr,
+		TsigName: "tlsautomate", TsigAlgorithm: "hmac-sha512", TsigSecret: secret,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := r.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	a := newRecord("_443._tcp.a.example.com", 1)
+	b := newRecord("_25._tcp.b.example.com", 2)
+	// Refused
+	outside := newRecord("_443._tcp.example.org", 3)
+	// Not authoritative
+	foreign := newRecord("_443._tcp.example.net", 4)
+
+	for i := 0; i < 2; i++ {
+		skipped, err := r.Update(ctx, nil, OutputRecordSet{a: {}, b: {}, outside: {}, foreign: {}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if expected := (OutputRecordSet{outside: {}, foreign: {}}); !reflect.DeepEqual(skipped, expected) {
+			t.Errorf("expected only the records outside the zones to be skipped, got %v", skipped)
+		}
+	}
+
+	srv.expect(t, "_443._tcp.a.example.com.", "_25._tcp.b.example.com.")
+
+	expectUpdate(ctx, t, r, OutputRecordSet{a: {}}, nil)
+	srv.expect(t, "_25._tcp.b.example.com.")
+
+	srv.Lock()
+	defer srv.Unlock()
+
+	if _, ok := srv.algorithms[dns.HmacSHA512]; !ok || len(srv.algorithms) != 1 {
+		t.Errorf("expected only %s, got %v", dns.HmacSHA512, srv.algorithms)
+	}
+
+	// Ping's one, then every zone once, but the ones outside every time
+	expected := map[string]int{
+		".": 1, "_443._tcp.a.example.com.": 1, "_25._tcp.b.example.com.": 1,
+		"_443._tcp.example.org.": 2, "_443._tcp.example.net.": 1,
+	}
+
+	if !reflect.DeepEqual(srv.soaQueries, expected) {
+		t.Errorf("expected SOA queries %v, got %v", expected, srv.soaQueries)
+	}
+}
+
+func TestRfc2136_Zones(t *testing.T) {
+	srv := serve(t)
+	r := &Rfc2136{
+		Numbered: Numbered{Nr: 1}, Server: srv.addr, Zones: []string{"example.com"},
+		TsigName: "tlsautomate", TsigAlgorithm: "hmac-sha256", TsigSecret: secret,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := r.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	a := newRecord("_443._tcp.a.example.com", 1)
+	outside := newRecord("_443._tcp.example.net", 2)
+
+	skipped, err := r.Update(ctx, nil, OutputRecordSet{a: {}, outside: {}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := skipped[outside]; !ok || len(skipped) != 1 {
+		t.Errorf("expected only the record outside the zones to be skipped, got %v", skipped)
+	}
+
+	srv.expect(t, "_443._tcp.a.example.com.")
+}
+
+func TestRfc2136_Ping(t *testing.T) {
+	srv := serve(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		name string
+		r    *Rfc2136
+	}{
+		{"bad secret", &Rfc2136{
+			Server: srv.addr, TsigName: "tlsautomate", TsigAlgorithm: "hmac-sha256",
+			TsigSecret: "d3JvbmcgYW5kIGluc2VjdXJlIGFuZCBzaG9ydAo=",
+		}},
+		{"unknown key", &Rfc2136{
+			Server: srv.addr, TsigName: "other", TsigAlgorithm: "hmac-sha256", TsigSecret: secret,
+		}},
+		{"foreign zone", &Rfc2136{
+			Server: srv.addr, Zones: []string{"example.net"},
+			TsigName: "tlsautomate", TsigAlgorithm: "hmac-sha256", TsigSecret: secret,
+		}},
+	} {
+		if err := tc.r.Ping(ctx); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
+
+type server struct {
+	*standIn
+
+	addr string
+}
+
+// serve serves a new standIn via TCP.
+func serve(t *testing.T) server {
+	t.Helper()
+
+	soa, err := dns.NewRR("example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 86400 300")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	foreignSoa, err := dns.NewRR("example.net. 3600 IN SOA ns1.example.net. hostmaster.example.net. 1 7200 3600 86400 300")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &standIn{
+		soa: soa, foreignSoa: foreignSoa, tlsa: map[[2]string]*dns.TLSA{},
+		algorithms: map[string]struct{}{}, soaQueries: map[string]int{},
+	}
+	started := make(chan struct{})
+
+	srv := &dns.Server{
+		Listener:          listener,
+		Handler:           s,
+		TsigSecret:        map[string]string{"tlsautomate.": secret},
+		NotifyStartedFunc: func() { close(started) },
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction {
+			// Including UPDATEs
+			return dns.MsgAccept
+		},
+	}
+
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	<-started
+
+	return server{s, listener.Addr().String()}
+}
+
+func expectUpdate(ctx context.Context, t *testing.T, r *Rfc2136, del, create OutputRecordSet) {
+	t.Helper()
+
+	skipped, err := r.Update(ctx, del, create)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(skipped) > 0 {
+		t.Errorf("expected no records to be skipped, got %v", skipped)
+	}
+}
+
+func newRecord(service string, data byte) OutputRecord {
+	return OutputRecord{
+		Record:   Record{Ttl: 3600, CertUsage: 3, Selector: 1, MatchType: 1},
+		Service:  service,
+		CertSpec: Base64er([]byte{data}),
+	}
+}
@@ -0,0 +1,163 @@
+package scan
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/Al2Klimov/FUeL.go"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"time"
+)
+
+type Target struct {
+	// Address is host:port.
+	Address string
+	// ServerName is sent via SNI and defaults to Address' host.
+	ServerName string
+	// StartTls is one of smtp, imap, pop3, xmpp and xmpp-server. Empty means implicit TLS.
+	StartTls string
+}
+
+type Scan struct {
+	Numbered
+
+	Targets  []Target
+	Interval time.Duration
+	Timeout  time.Duration
+
+	lastHash  [sha256.Size]byte
+	lastCerts map[Target]*x509.Certificate
+}
+
+// errIncomplete signals that not every target presented a certificate, yet.
+var errIncomplete = errors.New("not every target reached, yet")
+
+var _ Input = (*Scan)(nil)
+
+func (*Scan) Kind() string {
+	return "scan"
+}
+
+// Ping checks whether the configured STARTTLS protocols are known.
+func (s *Scan) Ping(context.Context) fuel.ErrorWithStack {
+	for _, target := range s.Targets {
+		if _, ok := starttls[target.StartTls]; !ok && target.StartTls != "" {
+			return fuel.AttachStackToError(fmt.Errorf("unknown STARTTLS protocol: %s", target.StartTls), 0)
+		}
+	}
+
+	return nil
+}
+
+// Poll connects to all targets every Interval and returns as soon as the presented leaves change.
+// Nothing is returned until every target has presented a certificate at least once,
+// until then the targets are retried with exponential backoff rather than every Interval.
+func (s *Scan) Poll(ctx context.Context, since time.Time) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
+	var backoff Backoff
+
+	for {
+		certs, asOf, err := PollPeriodically(ctx, s, since, s.Interval, &s.lastHash, nil, s.read)
+		if err != nil && errors.Is(err, errIncomplete) {
+			if err := backoff.Retry(ctx, s, err, "not every target reached, yet - not reporting"); err != nil {
+				return nil, time.Time{}, err
+			}
+
+			// Read at once
+			since = time.Time{}
+			continue
+		}
+
+		return certs, asOf, err
+	}
+}
+
+func (s *Scan) read(ctx context.Context) ([]*x509.Certificate, fuel.ErrorWithStack) {
+	if s.lastCerts == nil {
+		s.lastCerts = map[Target]*x509.Certificate{}
+	}
+
+	complete := true
+
+	var certs []*x509.Certificate
+	for _, target := range s.Targets {
+		logger := ProviderLog(s).WithFields(log.Fields{"address": target.Address, "server_name": target.ServerName})
+
+		leaf, err := s.handshake(ctx, target)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fuel.AttachStackToError(ctx.Err(), 0)
+			}
+
+			// Not to delete the records of a temporarily unreachable service
+			leaf = s.lastCerts[target]
+			logger.WithError(err).WithField("last_known", leaf != nil).Warn("can't get certificate")
+		} else {
+			s.lastCerts[target] = leaf
+		}
+
+		if leaf == nil {
+			complete = false
+		} else {
+			certs = append(certs, leaf)
+		}
+	}
+
+	if !complete {
+		return nil, fuel.AttachStackToError(errIncomplete, 0)
+	}
+
+	return certs, nil
+}
+
+// handshake returns the leaf presented by target.
+func (s *Scan) handshake(ctx context.Context, target Target) (*x509.Certificate, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", target.Address)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	serverName := target.ServerName
+	if serverName == "" {
+		if serverName, _, err = net.SplitHostPort(target.Address); err != nil {
+			return nil, err
+		}
+	}
+
+	if target.StartTls != "" {
+		if err := starttls[target.StartTls](conn, serverName); err != nil {
+			return nil, err
+		}
+	}
+
+	// We're only interested in what is presented, DANE-EE doesn't care about the chain anyway.
+	client := tls.Client(conn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err := client.Handshake(); err != nil {
+		return nil, err
+	}
+
+	peers := client.ConnectionState().PeerCertificates
+	if len(peers) < 1 {
+		return nil, errors.New("no certificate presented")
+	}
+
+	ProviderLog(s).WithFields(log.Fields{
+		"address": target.Address, "server_name": serverName, "chain": len(peers),
+	}).Trace("performed TLS handshake")
+
+	return peers[0], nil
+}
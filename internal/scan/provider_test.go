@@ -0,0 +1,262 @@
+package scan
+
+import (
+	. "TLSAutomate/internal"
+	"TLSAutomate/internal/test-certs"
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// standIn speaks dialect up to STARTTLS and then presents cert.
+// It refuses STARTTLS the next refuse times and remembers the last SNI.
+type standIn struct {
+	sync.Mutex
+
+	dialect    string
+	cert       tls.Certificate
+	refuse     int
+	serverName string
+}
+
+func (s *standIn) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	s.Lock()
+	refuse := s.refuse > 0
+	if refuse {
+		s.refuse--
+	}
+	s.Unlock()
+
+	if s.dialect != "" {
+		if err := dialects[s.dialect](conn, !refuse); err != nil || refuse {
+			return
+		}
+	}
+
+	server := tls.Server(conn, &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			s.Lock()
+			s.serverName = hello.ServerName
+			s.Unlock()
+
+			return &s.cert, nil
+		},
+	})
+
+	_ = server.Handshake()
+}
+
+// dialects are the server sides of starttls. They fail STARTTLS unless ok.
+var dialects = map[string]func(conn net.Conn, ok bool) error{
+	"smtp": func(conn net.Conn, ok bool) error {
+		return converse(conn, "220 mx.example.com ESMTP\r\n", "EHLO", "250-mx.example.com\r\n250 STARTTLS\r\n",
+			"STARTTLS", reply(ok, "220 Ready to start TLS\r\n", "454 TLS not available\r\n"))
+	},
+	"imap": func(conn net.Conn, ok bool) error {
+		return converse(conn, "* OK IMAP4rev1 ready\r\n",
+			"a1 STARTTLS", reply(ok, "* BYE soon\r\na1 OK Begin TLS\r\n", "a1 BAD no\r\n"))
+	},
+	"pop3": func(conn net.Conn, ok bool) error {
+		return converse(conn, "+OK POP3 ready\r\n", "STLS", reply(ok, "+OK Begin TLS\r\n", "-ERR no\r\n"))
+	},
+	"xmpp":        xmppDialect,
+	"xmpp-server": xmppDialect,
+}
+
+func reply(ok bool, success, failure string) string {
+	if ok {
+		return success
+	}
+
+	return failure
+}
+
+// converse writes greeting and then expects a line with the first prefix of exchange, replies the second, etc.
+func converse(conn net.Conn, greeting string, exchange ...string) error {
+	if _, err := io.WriteString(conn, greeting); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+	for i := 0; i < len(exchange); i += 2 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		if !strings.HasPrefix(line, exchange[i]) {
+			return fmt.Errorf("unexpected command: %q", line)
+		}
+
+		if _, err := io.WriteString(conn, exchange[i+1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func xmppDialect(conn net.Conn, ok bool) error {
+	header, err := readTag(conn, "<stream:stream")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(conn, "<?xml version='1.0'?><stream:stream xmlns='jabber:client' "+
+		"xmlns:stream='http://etherx.jabber.org/streams' id='1' from='example.com' version='1.0'>"+
+		"<stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls></stream:features>")
+	if err != nil {
+		return err
+	}
+
+	if _, err := readTag(conn, "<starttls"); err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(conn, reply(
+		ok, "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>", "<failure xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>",
+	))
+
+	if err == nil && !strings.Contains(header, "to='mx.example.com'") {
+		err = fmt.Errorf("unexpected stream header: %q", header)
+	}
+
+	return err
+}
+
+// readTag reads byte-wise until the tag starting with prefix has been closed and returns all of it.
+func readTag(conn net.Conn, prefix string) (string, error) {
+	var buf strings.Builder
+	b := make([]byte, 1)
+
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return "", err
+		}
+
+		buf.WriteByte(b[0])
+
+		if tag := buf.String(); b[0] == '>' && strings.Contains(tag, prefix) {
+			return tag, nil
+		}
+	}
+}
+
+func TestScan_Poll(t *testing.T) {
+	s := &Scan{Numbered: Numbered{Nr: 1}, Interval: time.Hour, Timeout: 10 * time.Second}
+	var standIns []*standIn
+
+	for _, dialect := range []string{"", "smtp", "imap", "pop3", "xmpp", "xmpp-server"} {
+		san := "tls.example.com"
+		if dialect != "" {
+			san = dialect + ".example.com"
+		}
+
+		si := &standIn{dialect: dialect, cert: newCert(t, san)}
+		standIns = append(standIns, si)
+		s.Targets = append(s.Targets, Target{Address: listen(t, si), ServerName: "mx.example.com", StartTls: dialect})
+	}
+
+	if err := s.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	expectPoll(t, s, "tls.example.com", "smtp.example.com", "imap.example.com",
+		"pop3.example.com", "xmpp.example.com", "xmpp-server.example.com")
+
+	for _, si := range standIns {
+		si.Lock()
+		if si.serverName != "mx.example.com" {
+			t.Errorf("%q: expected SNI mx.example.com, got %q", si.dialect, si.serverName)
+		}
+		si.Unlock()
+	}
+}
+
+func TestScan_Poll_Unreachable(t *testing.T) {
+	MinBackoff, MaxBackoff = time.Millisecond, time.Millisecond
+
+	smtp := &standIn{dialect: "smtp", cert: newCert(t, "smtp.example.com"), refuse: 3}
+	imap := &standIn{dialect: "imap", cert: newCert(t, "imap.example.com")}
+
+	s := &Scan{
+		Numbered: Numbered{Nr: 1}, Interval: time.Hour, Timeout: 10 * time.Second, Targets: []Target{
+			{Address: listen(t, smtp), ServerName: "mx.example.com", StartTls: "smtp"},
+			{Address: listen(t, imap), ServerName: "mx.example.com", StartTls: "imap"},
+		},
+	}
+
+	// Not after an Interval
+	expectPoll(t, s, "smtp.example.com", "imap.example.com")
+}
+
+func TestScan_Ping(t *testing.T) {
+	s := &Scan{Numbered: Numbered{Nr: 1}, Targets: []Target{{Address: "127.0.0.1:25", StartTls: "smpt"}}}
+
+	if err := s.Ping(context.Background()); err == nil {
+		t.Error("expected an error for an unknown STARTTLS protocol")
+	}
+}
+
+func expectPoll(t *testing.T, s *Scan, sans ...string) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	certs, _, err := s.Poll(ctx, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(certs) != len(sans) {
+		t.Fatalf("expected %d certs, got %d", len(sans), len(certs))
+	}
+
+	for i, cert := range certs {
+		if cert.DNSNames[0] != sans[i] {
+			t.Errorf("expected cert #%d for %s, got %v", i+1, sans[i], cert.DNSNames)
+		}
+	}
+}
+
+// listen serves si via a new local listener and returns its address.
+func listen(t *testing.T, si *standIn) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go si.serve(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func newCert(t *testing.T, san string) tls.Certificate {
+	t.Helper()
+
+	cert, key := testcerts.New(t, san, testcerts.Options{})
+	return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key, Leaf: cert}
+}
@@ -0,0 +1,170 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// starttls upgrades plain text connections to the point where the TLS handshake may begin.
+var starttls = map[string]func(conn net.Conn, serverName string) error{
+	"smtp": func(conn net.Conn, _ string) error {
+		r := bufio.NewReader(conn)
+
+		if err := expectSmtp(r, "220"); err != nil {
+			return err
+		}
+
+		if _, err := io.WriteString(conn, "EHLO tlsautomate.invalid\r\n"); err != nil {
+			return err
+		}
+
+		if err := expectSmtp(r, "250"); err != nil {
+			return err
+		}
+
+		if _, err := io.WriteString(conn, "STARTTLS\r\n"); err != nil {
+			return err
+		}
+
+		return expectSmtp(r, "220")
+	},
+	"imap": func(conn net.Conn, _ string) error {
+		r := bufio.NewReader(conn)
+
+		if err := expectLine(r, "* OK"); err != nil {
+			return err
+		}
+
+		if _, err := io.WriteString(conn, "a1 STARTTLS\r\n"); err != nil {
+			return err
+		}
+
+		for {
+			line, err := readLine(r)
+			if err != nil {
+				return err
+			}
+
+			// Skip untagged responses
+			if !strings.HasPrefix(line, "* ") {
+				return expectPrefix(line, "a1 OK")
+			}
+		}
+	},
+	"pop3": func(conn net.Conn, _ string) error {
+		r := bufio.NewReader(conn)
+
+		if err := expectLine(r, "+OK"); err != nil {
+			return err
+		}
+
+		if _, err := io.WriteString(conn, "STLS\r\n"); err != nil {
+			return err
+		}
+
+		return expectLine(r, "+OK")
+	},
+	"xmpp": func(conn net.Conn, serverName string) error {
+		return xmpp(conn, serverName, "jabber:client")
+	},
+	"xmpp-server": func(conn net.Conn, serverName string) error {
+		return xmpp(conn, serverName, "jabber:server")
+	},
+}
+
+func xmpp(conn net.Conn, serverName, ns string) error {
+	_, err := fmt.Fprintf(
+		conn,
+		"<?xml version='1.0'?><stream:stream to='%s' xmlns='%s' "+
+			"xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>",
+		serverName, ns,
+	)
+	if err != nil {
+		return err
+	}
+
+	if _, err := readUntil(conn, "</stream:features>"); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(conn, "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"); err != nil {
+		return err
+	}
+
+	found, err := readUntil(conn, "<proceed", "<failure")
+	if err == nil && found != "<proceed" {
+		err = errors.New("STARTTLS failed")
+	}
+
+	return err
+}
+
+// expectSmtp reads a (multiline) SMTP reply and checks its code.
+func expectSmtp(r *bufio.Reader, code string) error {
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return err
+		}
+
+		if err := expectPrefix(line, code); err != nil {
+			return err
+		}
+
+		if !strings.HasPrefix(line, code+"-") {
+			return nil
+		}
+	}
+}
+
+func expectLine(r *bufio.Reader, prefix string) error {
+	line, err := readLine(r)
+	if err != nil {
+		return err
+	}
+
+	return expectPrefix(line, prefix)
+}
+
+func expectPrefix(line, prefix string) error {
+	if !strings.HasPrefix(line, prefix) {
+		return fmt.Errorf("unexpected response: %q", line)
+	}
+
+	return nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// readUntil reads XML byte-wise (not to consume the TLS handshake)
+// until one of markers has been read and the tag containing it has been closed.
+func readUntil(conn net.Conn, markers ...string) (string, error) {
+	var buf bytes.Buffer
+	b := make([]byte, 1)
+
+	for {
+		if bytes.HasSuffix(buf.Bytes(), []byte(">")) {
+			for _, marker := range markers {
+				if bytes.Contains(buf.Bytes(), []byte(marker)) {
+					return marker, nil
+				}
+			}
+		}
+
+		if _, err := conn.Read(b); err != nil {
+			return "", err
+		}
+
+		if buf.WriteByte(b[0]); buf.Len() > 1<<20 {
+			return "", fmt.Errorf("none of %q received within 1 MiB", markers)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package static
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"crypto/x509"
+	"github.com/Al2Klimov/FUeL.go"
+	"time"
+)
+
+// Static provides certificates given in the config, inline or as files.
+type Static struct {
+	Numbered
+
+	// Pem are inline PEM certificates.
+	Pem   []string
+	Files []string
+}
+
+var _ Input = (*Static)(nil)
+
+func (*Static) Kind() string {
+	return "static"
+}
+
+func (s *Static) Ping(context.Context) fuel.ErrorWithStack {
+	_, err := s.inline()
+	return err
+}
+
+// Poll returns the inline certificates and the files' ones, the latter on every change.
+func (s *Static) Poll(ctx context.Context, since time.Time) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
+	if len(s.Files) < 1 {
+		if since == (time.Time{}) {
+			certs, err := s.inline()
+			return certs, time.Now(), err
+		}
+
+		// Nothing can change.
+		<-ctx.Done()
+		return nil, time.Time{}, fuel.AttachStackToError(ctx.Err(), 0)
+	}
+
+	return PollFiles(ctx, s, since, func() []string { return DirsOf(s.Files...) }, s.read)
+}
+
+func (s *Static) read(since time.Time) ([]*x509.Certificate, bool, time.Time, fuel.ErrorWithStack) {
+	certs, changed, mt, err := ReadLeaves(s, since, DirsOf(s.Files...), s.Files)
+	if err != nil || !changed {
+		return nil, changed, mt, err
+	}
+
+	inline, err := s.inline()
+	if err != nil {
+		return nil, false, time.Time{}, err
+	}
+
+	return append(inline, certs...), true, mt, nil
+}
+
+func (s *Static) inline() ([]*x509.Certificate, fuel.ErrorWithStack) {
+	certs := make([]*x509.Certificate, 0, len(s.Pem))
+	for _, pem := range s.Pem {
+		leaf, err := ParseLeaf([]byte(pem))
+		if err != nil {
+			return nil, fuel.AttachStackToError(err, 0)
+		}
+
+		certs = append(certs, leaf)
+	}
+
+	return certs, nil
+}
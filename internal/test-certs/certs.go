@@ -0,0 +1,70 @@
+// Package testcerts creates certificates for tests.
+package testcerts
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// Options customize New's certificates. The zero value is fine.
+type Options struct {
+	// Key defaults to a new ECDSA P-256 one.
+	Key crypto.Signer
+	// NotBefore defaults to an hour ago.
+	NotBefore time.Time
+	// Extensions are added as they are, e.g. the CT poison one of precerts.
+	Extensions []pkix.Extension
+}
+
+// New creates a self-signed TLS server certificate for san, valid for two hours, and returns it with its key.
+func New(t testing.TB, san string, opts Options) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+
+	key := opts.Key
+	if key == nil {
+		var err error
+		if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notBefore := opts.NotBefore
+	if notBefore.IsZero() {
+		notBefore = time.Now().Add(-time.Hour)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: san},
+		DNSNames:        []string{san},
+		NotBefore:       notBefore,
+		NotAfter:        notBefore.Add(2 * time.Hour),
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		ExtraExtensions: opts.Extensions,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+// PEM encodes cert.
+func PEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
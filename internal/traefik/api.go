@@ -0,0 +1,269 @@
+package traefik
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Al2Klimov/FUeL.go"
+	"github.com/hashicorp/go-cleanhttp"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraefikApi discovers the ports the hosts of Traefik's TLS routers are served on.
+type TraefikApi struct {
+	Numbered
+
+	// Api is the base URL, e.g. http://traefik:8080 (may include basic auth).
+	Api      string
+	Interval time.Duration
+
+	once         sync.Once
+	client       *http.Client
+	lastServices HostServices
+}
+
+var _ Discovery = (*TraefikApi)(nil)
+
+type entryPoint struct {
+	Name    string          `json:"name"`
+	Address string          `json:"address"`
+	Http3   json.RawMessage `json:"http3"`
+}
+
+type router struct {
+	Name        string          `json:"name"`
+	EntryPoints []string        `json:"entryPoints"`
+	Rule        string          `json:"rule"`
+	Tls         json.RawMessage `json:"tls"`
+	Status      string          `json:"status"`
+}
+
+type httpStatus uint16
+
+var _ error = httpStatus(0)
+
+func (hs httpStatus) Error() string {
+	return fmt.Sprintf("HTTP status: %d", int(hs))
+}
+
+var hostRule = regexp.MustCompile("\\bHost(?:SNI)?\\(([^)]*)\\)")
+var ruleArg = regexp.MustCompile("`([^`]*)`|\"([^\"]*)\"")
+
+func (*TraefikApi) Kind() string {
+	return "Traefik API"
+}
+
+func (t *TraefikApi) Ping(ctx context.Context) fuel.ErrorWithStack {
+	return t.get(ctx, "api/entrypoints", new([]entryPoint))
+}
+
+// Discover asks the API every Interval and returns as soon as the result changes.
+// Errors (e.g. while Traefik restarts) are retried with exponential backoff, so the last services stay.
+func (t *TraefikApi) Discover(ctx context.Context, since time.Time) (HostServices, time.Time, fuel.ErrorWithStack) {
+	var backoff Backoff
+
+	for {
+		if since != (time.Time{}) {
+			timer := time.NewTimer(time.Until(since.Add(t.Interval)))
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, time.Time{}, fuel.AttachStackToError(ctx.Err(), 0)
+			case <-timer.C:
+			}
+		}
+
+		since = time.Now()
+
+		services, err := t.discover(ctx)
+		if err != nil {
+			if err := backoff.Retry(ctx, t, err, "can't query API, keeping the last services"); err != nil {
+				return nil, time.Time{}, err
+			}
+
+			// Ask at once
+			since = time.Time{}
+			continue
+		}
+
+		backoff.Reset()
+
+		if t.lastServices != nil && reflect.DeepEqual(services, t.lastServices) {
+			ProviderLog(t).Trace("services didn't change")
+			continue
+		}
+
+		t.lastServices = services
+		return services, since, nil
+	}
+}
+
+func (t *TraefikApi) discover(ctx context.Context) (HostServices, fuel.ErrorWithStack) {
+	var eps []entryPoint
+	if err := t.get(ctx, "api/entrypoints", &eps); err != nil {
+		return nil, err
+	}
+
+	epServices := make(map[string][]string, len(eps))
+	var allServices []string
+
+	for _, ep := range eps {
+		port, proto, err := parseAddress(ep.Address)
+		if err != nil {
+			ProviderLog(t).WithError(err).WithField("entrypoint", ep.Name).Warn("can't parse entrypoint address")
+			continue
+		}
+
+		services := []string{fmt.Sprintf("_%d._%s", port, proto)}
+		if proto == "tcp" && len(ep.Http3) > 0 && string(ep.Http3) != "null" {
+			services = append(services, fmt.Sprintf("_%d._udp", port))
+		}
+
+		epServices[ep.Name] = services
+		allServices = append(allServices, services...)
+	}
+
+	hostServices := HostServices{}
+
+	for _, kind := range [2]string{"http", "tcp"} {
+		var routers []router
+		if err := t.get(ctx, "api/"+kind+"/routers", &routers); err != nil {
+			return nil, err
+		}
+
+		for _, r := range routers {
+			if r.Status != "" && r.Status != "enabled" {
+				continue
+			}
+
+			services := allServices
+			if len(r.EntryPoints) > 0 {
+				services = nil
+				for _, ep := range r.EntryPoints {
+					services = append(services, epServices[ep]...)
+				}
+			}
+
+			tls := len(r.Tls) > 0 && string(r.Tls) != "null"
+
+			for _, host := range parseHosts(r.Rule) {
+				perHost, ok := hostServices[host]
+				if !ok {
+					// Known, but maybe not served via TLS at all
+					perHost = map[string]struct{}{}
+					hostServices[host] = perHost
+				}
+
+				if tls {
+					for _, svc := range services {
+						perHost[svc] = struct{}{}
+					}
+				}
+			}
+
+			ProviderLog(t).WithFields(log.Fields{
+				"router": r.Name, "rule": r.Rule, "tls": tls, "services": services,
+			}).Trace("discovered router")
+		}
+	}
+
+	return hostServices, nil
+}
+
+// get fetches all pages of a list from the API.
+func (t *TraefikApi) get(ctx context.Context, path string, resp interface{}) fuel.ErrorWithStack {
+	t.once.Do(func() { t.client = cleanhttp.DefaultPooledClient() })
+
+	base, err := url.Parse(strings.TrimSuffix(t.Api, "/") + "/")
+	if err != nil {
+		return fuel.AttachStackToError(err, 0)
+	}
+
+	vResp := reflect.ValueOf(resp).Elem()
+
+	for page := 1; ; {
+		query := url.Values{"page": []string{strconv.Itoa(page)}}
+		uri := base.ResolveReference(&url.URL{Path: path, RawQuery: query.Encode()})
+
+		req, err := http.NewRequestWithContext(ctx, "GET", uri.String(), nil)
+		if err != nil {
+			return fuel.AttachStackToError(err, 0)
+		}
+
+		response, err := t.client.Do(req)
+		logger := ProviderLog(t).WithField("url", uri.Redacted())
+
+		if err != nil {
+			logger.WithError(err).Debug("performed HTTP request")
+			return fuel.AttachStackToError(err, 0)
+		}
+
+		logger.WithField("status", response.StatusCode).Debug("performed HTTP request")
+
+		if response.StatusCode > 299 {
+			_ = response.Body.Close()
+			return fuel.AttachStackToError(httpStatus(response.StatusCode), 0)
+		}
+
+		vPage := reflect.New(vResp.Type())
+		err = json.NewDecoder(response.Body).Decode(vPage.Interface())
+		_ = response.Body.Close()
+
+		if err != nil {
+			return fuel.AttachStackToError(err, 0)
+		}
+
+		vResp.Set(reflect.AppendSlice(vResp, vPage.Elem()))
+
+		// On the last page this is 1.
+		next, _ := strconv.Atoi(response.Header.Get("X-Next-Page"))
+		if next <= page {
+			return nil
+		}
+
+		page = next
+	}
+}
+
+// parseAddress parses entrypoint addresses like ":443" or "0.0.0.0:443/udp".
+func parseAddress(address string) (uint16, string, error) {
+	proto := "tcp"
+	if i := strings.LastIndexByte(address, '/'); i >= 0 {
+		proto = strings.ToLower(address[i+1:])
+		address = address[:i]
+	}
+
+	_, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return 0, "", err
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	return uint16(port), proto, err
+}
+
+// parseHosts returns the arguments of all Host and HostSNI matchers in a rule.
+func parseHosts(rule string) []string {
+	var hosts []string
+	for _, matcher := range hostRule.FindAllStringSubmatch(rule, -1) {
+		for _, arg := range ruleArg.FindAllStringSubmatch(matcher[1], -1) {
+			host := strings.ToLower(arg[1] + arg[2])
+			if host != "" && host != "*" {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+
+	return hosts
+}
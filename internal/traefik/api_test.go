@@ -0,0 +1,103 @@
+package traefik
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// standIn serves routers via the API. It drops the connection of the next drops requests
+// and fails the next fails ones, e.g. while restarting.
+type standIn struct {
+	sync.Mutex
+
+	routers []router
+	drops   int
+	fails   int
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.drops > 0 {
+		s.drops--
+
+		if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+			_ = conn.Close()
+		}
+
+		return
+	}
+
+	if s.fails > 0 {
+		s.fails--
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/api/entrypoints":
+		_ = json.NewEncoder(w).Encode([]entryPoint{{Name: "websecure", Address: ":443"}})
+	case "/api/http/routers":
+		_ = json.NewEncoder(w).Encode(s.routers)
+	case "/api/tcp/routers":
+		_ = json.NewEncoder(w).Encode([]router{})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *standIn) set(f func(s *standIn)) {
+	s.Lock()
+	defer s.Unlock()
+
+	f(s)
+}
+
+func TestTraefikApi_Discover(t *testing.T) {
+	MinBackoff, MaxBackoff = time.Millisecond, time.Millisecond
+
+	tls := json.RawMessage("{}")
+	traefik := &standIn{routers: []router{{Name: "a", Rule: "Host(`a.example.com`)", Tls: tls}}, drops: 2, fails: 2}
+
+	srv := httptest.NewServer(traefik)
+	defer srv.Close()
+
+	ta := &TraefikApi{Numbered: Numbered{Nr: 1}, Api: srv.URL, Interval: time.Millisecond}
+
+	asOf := expectDiscover(t, ta, time.Time{}, HostServices{"a.example.com": {"_443._tcp": {}}})
+
+	// Restarting
+	traefik.set(func(s *standIn) {
+		s.routers = append(s.routers, router{Name: "b", Rule: "Host(`b.example.com`)", Tls: tls})
+		s.drops = 2
+		s.fails = 2
+	})
+
+	expectDiscover(t, ta, asOf, HostServices{"a.example.com": {"_443._tcp": {}}, "b.example.com": {"_443._tcp": {}}})
+}
+
+func expectDiscover(t *testing.T, ta *TraefikApi, since time.Time, expected HostServices) time.Time {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	services, asOf, err := ta.Discover(ctx, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(services, expected) {
+		t.Errorf("expected %v, got %v", expected, services)
+	}
+
+	return asOf
+}
@@ -0,0 +1,193 @@
+package traefik
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"crypto/x509"
+	"github.com/Al2Klimov/FUeL.go"
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// TraefikFile reads the certificates referenced by Traefik's file provider, i.e. dynamic configuration.
+type TraefikFile struct {
+	Numbered
+
+	// Path is a file or a directory like the file provider's filename or directory.
+	Path string
+}
+
+var _ Input = (*TraefikFile)(nil)
+
+type fileOrContent = string
+
+type dynamicConfig struct {
+	Tls struct {
+		Certificates []struct {
+			CertFile fileOrContent `yaml:"certFile" toml:"certFile"`
+		} `yaml:"certificates" toml:"certificates"`
+		Stores map[string]struct {
+			DefaultCertificate *struct {
+				CertFile fileOrContent `yaml:"certFile" toml:"certFile"`
+			} `yaml:"defaultCertificate" toml:"defaultCertificate"`
+		} `yaml:"stores" toml:"stores"`
+	} `yaml:"tls" toml:"tls"`
+}
+
+func (*TraefikFile) Kind() string {
+	return "Traefik file provider"
+}
+
+func (t *TraefikFile) Ping(context.Context) fuel.ErrorWithStack {
+	_, err := os.Stat(t.Path)
+	return fuel.AttachStackToError(err, 0)
+}
+
+func (t *TraefikFile) Poll(ctx context.Context, since time.Time) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
+	return PollFiles(ctx, t, since, t.dirs, t.read)
+}
+
+func (t *TraefikFile) read(since time.Time) ([]*x509.Certificate, bool, time.Time, fuel.ErrorWithStack) {
+	configDirs, configFiles, err := t.configFiles()
+	if err != nil {
+		return nil, false, time.Time{}, err
+	}
+
+	certFiles, inline, ok := t.parse(configFiles)
+	if !ok {
+		return nil, false, since, nil
+	}
+
+	certs, ok, mt, err := ReadLeaves(t, since, append(configDirs, configFiles...), certFiles)
+	if !ok || err != nil {
+		return certs, ok, mt, err
+	}
+
+	for _, content := range inline {
+		leaf, err := ParseLeaf([]byte(content))
+		if err != nil {
+			ProviderLog(t).WithError(err).Warn("can't parse inline PEM")
+			continue
+		}
+
+		certs = append(certs, leaf)
+	}
+
+	return certs, true, mt, nil
+}
+
+// dirs returns the config directories and the ones containing config and certificate files.
+func (t *TraefikFile) dirs() []string {
+	configDirs, configFiles, err := t.configFiles()
+	if err != nil {
+		ProviderLog(t).WithError(err).Warn("can't list config files")
+	}
+
+	certFiles, _, _ := t.parse(configFiles)
+	return append(append(configDirs, DirsOf(configFiles...)...), DirsOf(certFiles...)...)
+}
+
+// configFiles returns Path if it's a file or all *.{yml,yaml,toml} in it (recursively) and the directories visited.
+func (t *TraefikFile) configFiles() (dirs []string, files []string, err fuel.ErrorWithStack) {
+	st, errSt := os.Stat(t.Path)
+	if errSt != nil {
+		if os.IsNotExist(errSt) {
+			return nil, nil, nil
+		}
+
+		return nil, nil, fuel.AttachStackToError(errSt, 0)
+	}
+
+	if !st.IsDir() {
+		return nil, []string{t.Path}, nil
+	}
+
+	pending := []string{t.Path}
+	for len(pending) > 0 {
+		dir := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		dirs = append(dirs, dir)
+
+		entries, errRD := ioutil.ReadDir(dir)
+		if errRD != nil {
+			if os.IsNotExist(errRD) {
+				continue
+			}
+
+			return nil, nil, fuel.AttachStackToError(errRD, 0)
+		}
+
+		for _, entry := range entries {
+			switch name := path.Join(dir, entry.Name()); {
+			case entry.IsDir():
+				pending = append(pending, name)
+			case decoders[path.Ext(name)] != nil:
+				files = append(files, name)
+			}
+		}
+	}
+
+	return
+}
+
+// parse returns the certificate files and inline PEM certificates configured in configFiles.
+// Errors are logged and assumed to be temporary (e.g. while editing), ok is false then.
+func (t *TraefikFile) parse(configFiles []string) (certFiles []string, inline []string, ok bool) {
+	ok = true
+
+	for _, configFile := range configFiles {
+		logger := ProviderLog(t).WithField("file", configFile)
+
+		content, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				logger.WithError(err).Warn("can't read config file, assuming error is temporary")
+				ok = false
+			}
+
+			continue
+		}
+
+		var config dynamicConfig
+		if err := decoders[path.Ext(configFile)](content, &config); err != nil {
+			logger.WithError(err).Warn("can't parse config file, assuming error is temporary")
+			ok = false
+			continue
+		}
+
+		var certs []fileOrContent
+		for _, cert := range config.Tls.Certificates {
+			certs = append(certs, cert.CertFile)
+		}
+
+		for _, store := range config.Tls.Stores {
+			if store.DefaultCertificate != nil {
+				certs = append(certs, store.DefaultCertificate.CertFile)
+			}
+		}
+
+		for _, cert := range certs {
+			switch {
+			case strings.Contains(cert, "-----BEGIN"):
+				inline = append(inline, cert)
+			case strings.TrimSpace(cert) != "":
+				certFiles = append(certFiles, cert)
+			}
+		}
+
+		logger.WithField("certs", len(certs)).Trace("parsed config file")
+	}
+
+	return
+}
+
+var decoders = map[string]func([]byte, interface{}) error{
+	".yml":  yaml.Unmarshal,
+	".yaml": yaml.Unmarshal,
+	".toml": toml.Unmarshal,
+}
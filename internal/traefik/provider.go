@@ -2,15 +2,13 @@ package traefik
 
 import (
 	. "TLSAutomate/internal"
-	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"github.com/Al2Klimov/FUeL.go"
-	"github.com/fsnotify/fsnotify"
 	log "github.com/sirupsen/logrus"
-	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -21,14 +19,38 @@ type Traefik struct {
 	Numbered
 
 	AcmeJson string
+	// Resolvers limits the certificate resolvers to read, all are read if empty.
+	// Traefik v1's certificates belong to the resolver "acme".
+	Resolvers []string
+	// CheckKeys cross-checks the certificates against their keys (if any) and skips mismatching ones.
+	// The keys aren't needed otherwise, i.e. a copy of acme.json without them suffices.
+	CheckKeys bool
+
+	schema string
+	// lastHash is the SHA-256 sum of the last decoded content, zero if none.
+	lastHash [sha256.Size]byte
+	// badHash is the SHA-256 sum of the last content which couldn't be decoded, e.g. a half-written one.
+	badHash [sha256.Size]byte
+	// cache maps the digests of the PEMs of the last read content (see digest) to their leaves, nil if bad.
+	cache map[[sha256.Size]byte]*x509.Certificate
 }
 
 var _ Input = (*Traefik)(nil)
+var _ Qualified = (*Traefik)(nil)
 
 func (*Traefik) Kind() string {
 	return "Traefik"
 }
 
+// LogFields tell apart the inputs per resolver of the same acme.json.
+func (t *Traefik) LogFields() log.Fields {
+	if len(t.Resolvers) < 1 {
+		return nil
+	}
+
+	return log.Fields{"resolvers": t.Resolvers}
+}
+
 func (t *Traefik) Ping(context.Context) fuel.ErrorWithStack {
 	if _, err := ioutil.ReadDir(t.acmeDir()); err != nil {
 		return fuel.AttachStackToError(err, 0)
@@ -43,120 +65,127 @@ func (t *Traefik) Ping(context.Context) fuel.ErrorWithStack {
 }
 
 func (t *Traefik) Poll(ctx context.Context, since time.Time) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
-	{
-		certs, ok, mt, err := t.read(since)
-		if err != nil {
-			return nil, time.Time{}, err
-		}
+	// Also the directory of the symlink target, if any, e.g. ..data/acme.json
+	return PollFiles(ctx, t, since, func() []string { return DirsOf(t.AcmeJson) }, t.read)
+}
 
-		if ok {
-			return certs, mt, nil
+// read reads acme.json unless its content didn't change since the last call.
+// (Its mod time may not change with quick successive writes and may change without any actual change.)
+func (t *Traefik) read(since time.Time) ([]*x509.Certificate, bool, time.Time, fuel.ErrorWithStack) {
+	content, errRF := ioutil.ReadFile(t.AcmeJson)
+	if errRF != nil {
+		if os.IsNotExist(errRF) {
+			ProviderLog(t).Warn("ACME JSON file doesn't exist, assuming only temporarily")
+			return nil, false, since, nil
 		}
 
-		since = mt
+		return nil, false, time.Time{}, fuel.AttachStackToError(errRF, 0)
 	}
 
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, time.Time{}, fuel.AttachStackToError(err, 0)
+	asOf := time.Now()
+
+	hash := sha256.Sum256(content)
+	if hash == t.lastHash || hash == t.badHash {
+		ProviderLog(t).Trace("ACME JSON file's content didn't change")
+		return nil, false, since, nil
 	}
-	defer func() { _ = watcher.Close() }()
 
-	if err := watcher.Add(t.acmeDir()); err != nil {
-		return nil, time.Time{}, fuel.AttachStackToError(err, 0)
+	acmeData, schema, errDc := decodeAcmeJson(content)
+	if errDc != nil {
+		ProviderLog(t).WithError(errDc).Warn("can't decode ACME JSON, assuming error is temporary")
+		t.badHash = hash
+
+		return nil, false, since, nil
 	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return nil, time.Time{}, fuel.AttachStackToError(ctx.Err(), 0)
-		case err := <-watcher.Errors:
-			ProviderLog(t).WithError(err).Warn("FS watch error, assuming queue overflow")
-		case <-watcher.Events:
-		}
+	t.lastHash = hash
 
-		certs, ok, mt, err := t.read(since)
-		if err != nil {
-			return nil, time.Time{}, err
-		}
+	if schema != t.schema {
+		ProviderLog(t).WithFields(log.Fields{"file": t.AcmeJson, "schema": schema}).Info("detected ACME JSON schema")
+		t.schema = schema
+	}
 
-		if ok {
-			return certs, mt, nil
+	var certs []*x509.Certificate
+	cache := map[[sha256.Size]byte]*x509.Certificate{}
+	cached := 0
+
+	for resolver, resolverCerts := range acmeData {
+		if !t.wants(resolver) {
+			ProviderLog(t).WithField("resolver", resolver).Trace("skipping certificate resolver")
+			continue
 		}
 
-		since = mt
+		for _, cert := range resolverCerts {
+			digest := t.digest(cert)
+
+			leaf, ok := t.cache[digest]
+			if ok {
+				cached++
+			} else {
+				leaf = t.parse(cert)
+			}
+
+			cache[digest] = leaf
+
+			if leaf != nil {
+				certs = append(certs, leaf)
+			}
+		}
 	}
+
+	ProviderLog(t).WithFields(log.Fields{"certs": len(cache), "cached": cached}).Trace("parsed ACME JSON certificates")
+
+	// Only the current ones, to forget removed certificates
+	t.cache = cache
+
+	return certs, true, asOf, nil
 }
 
-func (t *Traefik) read(since time.Time) ([]*x509.Certificate, bool, time.Time, fuel.ErrorWithStack) {
-	f, errOp := os.Open(t.AcmeJson)
-	if errOp != nil {
-		if os.IsNotExist(errOp) {
-			ProviderLog(t).Warn("ACME JSON file doesn't exist, assuming only temporarily")
-			return nil, false, since, nil
-		}
+// digest identifies cert's PEM (and key if checked) in the cache.
+func (t *Traefik) digest(cert acmeCert) [sha256.Size]byte {
+	h := sha256.New()
+	_, _ = h.Write(cert.Certificate)
 
-		return nil, false, time.Time{}, fuel.AttachStackToError(errOp, 0)
+	if t.CheckKeys {
+		_, _ = h.Write(cert.Key)
 	}
-	defer func() { _ = f.Close() }()
 
-	st, errSt := f.Stat()
-	if errSt != nil {
-		return nil, false, time.Time{}, fuel.AttachStackToError(errSt, 0)
-	}
+	var digest [sha256.Size]byte
+	h.Sum(digest[:0])
 
-	mt := st.ModTime()
-	if !mt.After(since) {
-		ProviderLog(t).WithFields(log.Fields{
-			"mtime": mt, "since": since,
-		}).Trace("ACME JSON file's mod time didn't change")
-		return nil, false, since, nil
-	}
+	return digest
+}
 
-	buf := &bytes.Buffer{}
-	if _, errCp := io.Copy(buf, f); errCp != nil {
-		return nil, false, time.Time{}, fuel.AttachStackToError(errCp, 0)
+// parse returns cert's leaf or nil if it's bad.
+func (t *Traefik) parse(cert acmeCert) *x509.Certificate {
+	leaf, err := ParseLeaf(cert.Certificate)
+	if err != nil {
+		ProviderLog(t).WithError(err).WithField("domain", cert.Domain.Main).Warn("can't parse PEM")
+		return nil
 	}
 
-	var acmeData map[string]struct {
-		Certificates []struct {
-			Certificate []byte `json:"certificate"`
-			Key         []byte `json:"key"`
+	if t.CheckKeys {
+		if err := checkKey(cert); err != nil {
+			ProviderLog(t).WithError(err).WithField("domain", cert.Domain.Main).Warn("certificate doesn't match its key")
+			return nil
 		}
 	}
-	if err := json.NewDecoder(buf).Decode(&acmeData); err != nil {
-		ProviderLog(t).WithError(err).Warn("can't decode ACME JSON, assuming error is temporary")
-		return nil, false, mt, nil
-	}
 
-	var certs []*x509.Certificate
-	for _, resolver := range acmeData {
-		for _, cert := range resolver.Certificates {
-			cert, err := tls.X509KeyPair(cert.Certificate, cert.Key)
-			if err != nil {
-				ProviderLog(t).WithError(err).Warn("can't parse PEM")
-				continue
-			}
+	return leaf
+}
 
-			if cert.Leaf == nil {
-				if len(cert.Certificate) < 1 {
-					ProviderLog(t).Warn("PEM doesn't contain any leaves")
-				} else {
-					leaf, err := x509.ParseCertificate(cert.Certificate[0])
-					if err != nil {
-						ProviderLog(t).WithError(err).Warn("can't parse PEM")
-						continue
-					}
-
-					certs = append(certs, leaf)
-				}
-			} else {
-				certs = append(certs, cert.Leaf)
-			}
+func (t *Traefik) wants(resolver string) bool {
+	if len(t.Resolvers) < 1 {
+		return true
+	}
+
+	for _, r := range t.Resolvers {
+		if r == resolver {
+			return true
 		}
 	}
 
-	return certs, true, mt, nil
+	return false
 }
 
 func (t *Traefik) acmeDir() string {
@@ -167,3 +196,22 @@ func (t *Traefik) acmeDir() string {
 
 	return dir
 }
+
+// checkKey verifies that cert.Key matches cert.Certificate unless there's no key.
+func checkKey(cert acmeCert) error {
+	if len(cert.Key) < 1 {
+		return nil
+	}
+
+	var key []byte
+	if err := json.Unmarshal(cert.Key, &key); err != nil {
+		return err
+	}
+
+	if len(key) < 1 {
+		return nil
+	}
+
+	_, err := tls.X509KeyPair(cert.Certificate, key)
+	return err
+}
@@ -0,0 +1,62 @@
+package traefik
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+type acmeCert struct {
+	Domain struct {
+		Main string   `json:"main"`
+		SANs []string `json:"sans"`
+	} `json:"domain"`
+	Certificate []byte `json:"certificate"`
+	// Key stays undecoded unless actually needed.
+	Key json.RawMessage `json:"key"`
+}
+
+// v1Resolver is the resolver name v1's certificates are returned under. (v1's config section is called "acme".)
+const v1Resolver = "acme"
+
+// acmeV1 is Traefik v1's acme.json.
+type acmeV1 struct {
+	Certificates []acmeCert
+}
+
+// acmeV2 is Traefik v2's acme.json, by resolver.
+type acmeV2 map[string]struct {
+	Certificates []acmeCert
+}
+
+// decodeAcmeJson decodes either schema and returns the certificates by resolver
+// (v1 only has the resolver v1Resolver) and the detected schema.
+func decodeAcmeJson(data []byte) (map[string][]acmeCert, string, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return nil, "", err
+	}
+
+	// In v2 this would be an object, i.e. a resolver called "Certificates".
+	if certs, ok := top["Certificates"]; ok {
+		if certs := bytes.TrimSpace(certs); bytes.HasPrefix(certs, []byte("[")) || bytes.Equal(certs, []byte("null")) {
+			var v1 acmeV1
+			if err := json.Unmarshal(data, &v1); err != nil {
+				return nil, "", err
+			}
+
+			return map[string][]acmeCert{v1Resolver: v1.Certificates}, "v1", nil
+		}
+	}
+
+	var v2 acmeV2
+	if err := json.Unmarshal(data, &v2); err != nil {
+		return nil, "", err
+	}
+
+	byResolver := make(map[string][]acmeCert, len(v2))
+	for resolver, data := range v2 {
+		byResolver[resolver] = data.Certificates
+	}
+
+	return byResolver, "v2", nil
+}
@@ -0,0 +1,257 @@
+package vault
+
+import (
+	. "TLSAutomate/internal"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"github.com/Al2Klimov/FUeL.go"
+	"github.com/hashicorp/go-cleanhttp"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// readKv returns the leaves of all PEM values of a KV v2 secret.
+func (v *Vault) readKv(ctx context.Context, kv KvPath) ([]*x509.Certificate, fuel.ErrorWithStack) {
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+
+	err := v.call(ctx, "GET", strings.Trim(kv.Mount, "/")+"/data/"+strings.Trim(kv.Path, "/"), nil, &secret)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]string, 0, len(secret.Data))
+	for field := range secret.Data {
+		fields = append(fields, field)
+	}
+
+	sort.Strings(fields)
+
+	var certs []*x509.Certificate
+	for _, field := range fields {
+		if pem, ok := secret.Data[field].(string); ok {
+			if leaf, err := ParseLeaf([]byte(pem)); err == nil {
+				certs = append(certs, leaf)
+			} else {
+				ProviderLog(v).WithError(err).WithFields(log.Fields{
+					"mount": kv.Mount, "path": kv.Path, "field": field,
+				}).Trace("secret field doesn't contain a certificate")
+			}
+		}
+	}
+
+	return certs, nil
+}
+
+// readPki returns all certificates issued by a PKI mount. Already known ones aren't fetched again.
+func (v *Vault) readPki(ctx context.Context, mount string) ([]*x509.Certificate, fuel.ErrorWithStack) {
+	mount = strings.Trim(mount, "/")
+
+	var serials struct {
+		Keys []string `json:"keys"`
+	}
+	if err := v.call(ctx, "GET", mount+"/certs?list=true", nil, &serials); err != nil {
+		if errors.Is(err, httpStatus(404)) {
+			// No certificates issued, yet
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	known := v.pkiCache[mount]
+	cache := make(map[string]*x509.Certificate, len(serials.Keys))
+	certs := make([]*x509.Certificate, 0, len(serials.Keys))
+
+	for _, serial := range serials.Keys {
+		leaf, ok := known[serial]
+		if !ok {
+			var cert struct {
+				Certificate string `json:"certificate"`
+			}
+			if err := v.call(ctx, "GET", mount+"/cert/"+url.PathEscape(serial), nil, &cert); err != nil {
+				return nil, err
+			}
+
+			var errPL error
+			if leaf, errPL = ParseLeaf([]byte(cert.Certificate)); errPL != nil {
+				ProviderLog(v).WithError(errPL).WithField("serial", serial).Warn("can't parse PEM")
+				continue
+			}
+		}
+
+		cache[serial] = leaf
+		certs = append(certs, leaf)
+	}
+
+	if v.pkiCache == nil {
+		v.pkiCache = map[string]map[string]*x509.Certificate{}
+	}
+
+	v.pkiCache[mount] = cache
+	return certs, nil
+}
+
+// login obtains a token via AppRole unless there's still a valid one.
+func (v *Vault) login(ctx context.Context) fuel.ErrorWithStack {
+	if v.AppRole == nil {
+		if v.token == "" {
+			token, err := ioutil.ReadFile(v.TokenFile)
+			if err != nil {
+				return fuel.AttachStackToError(err, 0)
+			}
+
+			v.token = strings.TrimSpace(string(token))
+		}
+
+		return nil
+	}
+
+	if v.token != "" && time.Now().Before(v.tokenExpiry) {
+		return nil
+	}
+
+	secretId, err := ioutil.ReadFile(v.AppRole.SecretIdFile)
+	if err != nil {
+		return fuel.AttachStackToError(err, 0)
+	}
+
+	var resp response
+	body := map[string]string{"role_id": v.AppRole.RoleId, "secret_id": strings.TrimSpace(string(secretId))}
+
+	v.token = ""
+	if err := v.rest(ctx, "POST", "auth/approle/login", body, &resp); err != nil {
+		return err
+	}
+
+	v.token = resp.Auth.ClientToken
+	v.tokenExpiry = time.Now().Add(time.Duration(resp.Auth.LeaseDuration) * time.Second / 2)
+
+	return nil
+}
+
+// call performs an API request with a valid token and decodes the response's data into resp.
+// On 403 it retries once with a new token.
+func (v *Vault) call(ctx context.Context, method, path string, body, resp interface{}) fuel.ErrorWithStack {
+	var r response
+	for attempt := 0; ; attempt++ {
+		if err := v.login(ctx); err != nil {
+			return err
+		}
+
+		err := v.rest(ctx, method, path, body, &r)
+		if err == nil {
+			break
+		}
+
+		if attempt > 0 || !errors.Is(err, httpStatus(403)) {
+			return err
+		}
+
+		ProviderLog(v).WithError(err).Debug("token rejected, retrying with a new one")
+	}
+
+	if resp != nil {
+		if err := json.Unmarshal(r.Data, resp); err != nil {
+			return fuel.AttachStackToError(err, 0)
+		}
+	}
+
+	return nil
+}
+
+func (v *Vault) rest(ctx context.Context, method, path string, body interface{}, resp *response) fuel.ErrorWithStack {
+	v.once.Do(v.init)
+	if v.initErr != nil {
+		return v.initErr
+	}
+
+	uri := v.base.ResolveReference(&url.URL{Path: path})
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		uri = v.base.ResolveReference(&url.URL{Path: path[:i], RawQuery: path[i+1:]})
+	}
+
+	req := (&http.Request{Method: method, URL: uri, Header: http.Header{}}).WithContext(ctx)
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+
+	if body != nil {
+		buf := &bytes.Buffer{}
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return fuel.AttachStackToError(err, 0)
+		}
+
+		req.Body = io.NopCloser(buf)
+		req.ContentLength = int64(buf.Len())
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := v.client.Do(req)
+	logger := ProviderLog(v).WithFields(log.Fields{"method": method, "url": uri.String()})
+
+	if err != nil {
+		logger.WithError(err).Debug("performed HTTP request")
+		return fuel.AttachStackToError(err, 0)
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	logger.WithField("status", response.StatusCode).Debug("performed HTTP request")
+
+	if response.StatusCode > 299 {
+		if response.StatusCode == 403 {
+			// Let the next login() obtain a new token or re-read TokenFile.
+			v.token = ""
+		}
+
+		return fuel.AttachStackToError(httpStatus(response.StatusCode), 0)
+	}
+
+	if resp != nil {
+		if err := json.NewDecoder(response.Body).Decode(resp); err != nil {
+			return fuel.AttachStackToError(err, 0)
+		}
+	}
+
+	return nil
+}
+
+func (v *Vault) init() {
+	base, err := url.Parse(strings.TrimSuffix(v.Address, "/") + "/v1/")
+	if err != nil {
+		v.initErr = fuel.AttachStackToError(err, 0)
+		return
+	}
+
+	v.base = base
+	tx := cleanhttp.DefaultPooledTransport()
+
+	if v.CaFile != "" {
+		ca, err := ioutil.ReadFile(v.CaFile)
+		if err != nil {
+			v.initErr = fuel.AttachStackToError(err, 0)
+			return
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			v.initErr = fuel.AttachStackToError(errors.New("CA file doesn't contain any certificates"), 0)
+			return
+		}
+
+		tx.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	v.client = &http.Client{Transport: tx, Timeout: time.Minute}
+}
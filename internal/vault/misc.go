@@ -0,0 +1,33 @@
+package vault
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type httpStatus uint16
+
+var _ error = httpStatus(0)
+
+func (hs httpStatus) Error() string {
+	return fmt.Sprintf("HTTP status: %d", int(hs))
+}
+
+// KvPath addresses a KV v2 secret.
+type KvPath struct {
+	Mount string
+	Path  string
+}
+
+type AppRole struct {
+	RoleId       string
+	SecretIdFile string
+}
+
+type response struct {
+	Data json.RawMessage `json:"data"`
+	Auth struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int64  `json:"lease_duration"`
+	} `json:"auth"`
+}
@@ -0,0 +1,90 @@
+package vault
+
+import (
+	. "TLSAutomate/internal"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"github.com/Al2Klimov/FUeL.go"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type Vault struct {
+	Numbered
+
+	Address string
+	CaFile  string
+	// TokenFile is used unless AppRole is given.
+	TokenFile string
+	AppRole   *AppRole
+	Interval  time.Duration
+	Kv        []KvPath
+	Pki       []string
+
+	once        sync.Once
+	initErr     fuel.ErrorWithStack
+	base        *url.URL
+	client      *http.Client
+	token       string
+	tokenExpiry time.Time
+	pkiCache    map[string]map[string]*x509.Certificate
+	lastHash    [sha256.Size]byte
+}
+
+var _ Input = (*Vault)(nil)
+
+func (*Vault) Kind() string {
+	return "Vault"
+}
+
+// Ping checks whether the token is valid.
+func (v *Vault) Ping(ctx context.Context) fuel.ErrorWithStack {
+	return v.call(ctx, "GET", "auth/token/lookup-self", nil, nil)
+}
+
+// Poll reads all configured paths every Interval and returns as soon as the certificates differ from the last ones.
+// Errors (e.g. a sealed or restarting Vault) are retried with exponential backoff, so the last certificates stay.
+func (v *Vault) Poll(ctx context.Context, since time.Time) ([]*x509.Certificate, time.Time, fuel.ErrorWithStack) {
+	var backoff Backoff
+
+	for {
+		certs, asOf, err := PollPeriodically(ctx, v, since, v.Interval, &v.lastHash, nil, v.read)
+		if err != nil {
+			if err := backoff.Retry(ctx, v, err, "can't read Vault, keeping the last certificates"); err != nil {
+				return nil, time.Time{}, err
+			}
+
+			// Read at once
+			since = time.Time{}
+			continue
+		}
+
+		return certs, asOf, nil
+	}
+}
+
+func (v *Vault) read(ctx context.Context) ([]*x509.Certificate, fuel.ErrorWithStack) {
+	var certs []*x509.Certificate
+	for _, kv := range v.Kv {
+		leaves, err := v.readKv(ctx, kv)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, leaves...)
+	}
+
+	for _, pki := range v.Pki {
+		leaves, err := v.readPki(ctx, pki)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, leaves...)
+	}
+
+	return certs, nil
+}
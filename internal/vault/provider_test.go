@@ -0,0 +1,190 @@
+package vault
+
+import (
+	. "TLSAutomate/internal"
+	"TLSAutomate/internal/test-certs"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// standIn serves the KV v2 secret secret/certs to token.
+// It drops the connection of the next drops requests and refuses the next sealed ones.
+type standIn struct {
+	sync.Mutex
+
+	token  string
+	pem    string
+	drops  int
+	sealed int
+	logins int
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.drops > 0 {
+		s.drops--
+
+		if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+			_ = conn.Close()
+		}
+
+		return
+	}
+
+	if s.sealed > 0 {
+		s.sealed--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v1/auth/approle/login":
+		s.logins++
+		s.token = "approle"
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": s.token, "lease_duration": 3600},
+		})
+	case "/v1/secret/data/certs":
+		if r.Header.Get("X-Vault-Token") != s.token {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{"data": map[string]interface{}{"tls.crt": s.pem}},
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (s *standIn) set(f func(s *standIn)) {
+	s.Lock()
+	defer s.Unlock()
+
+	f(s)
+}
+
+func TestVault_TokenFile(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	writeFile(t, tokenFile, "old\n")
+
+	vlt := &standIn{token: "old", pem: newCert(t, "a.example.com")}
+	srv := httptest.NewServer(vlt)
+	defer srv.Close()
+
+	v := &Vault{
+		Numbered: Numbered{Nr: 1}, Address: srv.URL, TokenFile: tokenFile,
+		Interval: time.Millisecond, Kv: []KvPath{{"secret", "certs"}},
+	}
+
+	asOf := expectPoll(t, v, time.Time{}, "a.example.com")
+
+	// Rotated
+	writeFile(t, tokenFile, "new\n")
+	vlt.set(func(s *standIn) {
+		s.token = "new"
+		s.pem = newCert(t, "b.example.com")
+	})
+
+	expectPoll(t, v, asOf, "b.example.com")
+}
+
+func TestVault_AppRole(t *testing.T) {
+	secretIdFile := filepath.Join(t.TempDir(), "secret-id")
+	writeFile(t, secretIdFile, "secret")
+
+	vlt := &standIn{pem: newCert(t, "a.example.com")}
+	srv := httptest.NewServer(vlt)
+	defer srv.Close()
+
+	v := &Vault{
+		Numbered: Numbered{Nr: 1}, Address: srv.URL, AppRole: &AppRole{RoleId: "role", SecretIdFile: secretIdFile},
+		Interval: time.Millisecond, Kv: []KvPath{{"secret", "certs"}},
+	}
+
+	asOf := expectPoll(t, v, time.Time{}, "a.example.com")
+
+	// Revoked
+	vlt.set(func(s *standIn) {
+		s.token = "revoked"
+		s.pem = newCert(t, "b.example.com")
+	})
+
+	expectPoll(t, v, asOf, "b.example.com")
+
+	vlt.set(func(s *standIn) {
+		if s.logins != 2 {
+			t.Errorf("expected 2 logins, got %d", s.logins)
+		}
+	})
+}
+
+func TestVault_Unavailable(t *testing.T) {
+	MinBackoff, MaxBackoff = time.Millisecond, time.Millisecond
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	writeFile(t, tokenFile, "token")
+
+	vlt := &standIn{token: "token", pem: newCert(t, "a.example.com"), drops: 2, sealed: 2}
+	srv := httptest.NewServer(vlt)
+	defer srv.Close()
+
+	v := &Vault{
+		Numbered: Numbered{Nr: 1}, Address: srv.URL, TokenFile: tokenFile,
+		Interval: time.Millisecond, Kv: []KvPath{{"secret", "certs"}},
+	}
+
+	asOf := expectPoll(t, v, time.Time{}, "a.example.com")
+
+	// Restarting
+	vlt.set(func(s *standIn) {
+		s.drops = 2
+		s.sealed = 2
+		s.pem = newCert(t, "b.example.com")
+	})
+
+	expectPoll(t, v, asOf, "b.example.com")
+}
+
+func expectPoll(t *testing.T, v *Vault, since time.Time, san string) time.Time {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	certs, asOf, err := v.Poll(ctx, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(certs) != 1 || len(certs[0].DNSNames) != 1 || certs[0].DNSNames[0] != san {
+		t.Fatalf("expected a cert for %s, got %d certs", san, len(certs))
+	}
+
+	return asOf
+}
+
+func newCert(t *testing.T, san string) string {
+	t.Helper()
+
+	cert, _ := testcerts.New(t, san, testcerts.Options{})
+	return string(testcerts.PEM(cert))
+}
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+
+	if err := ioutil.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
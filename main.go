@@ -6,6 +6,10 @@ import (
 	. "TLSAutomate/internal"
 	. "TLSAutomate/internal/business-logic"
 	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
@@ -14,9 +18,14 @@ import (
 	_ "github.com/Al2Klimov/go-gen-source-repos/noop"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"syscall"
+	"time"
 )
 
 func main() {
@@ -74,6 +83,7 @@ func loadConfig(configFile string) (*Config, fuel.ErrorWithStack) {
 	cfg.Records.CertUsage = 3
 	cfg.Records.Selector = 1
 	cfg.Records.MatchType = 1
+	cfg.Watch.ReReadInterval = time.Hour
 
 	if err := yaml.NewDecoder(f).Decode(cfg); err != nil {
 		return nil, fuel.AttachStackToError(err, 0)
@@ -88,7 +98,26 @@ func pingDb(db string) fuel.ErrorWithStack {
 }
 
 func validateConfig(cfg *Config) fuel.ErrorWithStack {
-	if len(cfg.Inputs.Traefik) < 1 {
+	inputs := len(cfg.Inputs.Traefik) +
+		len(cfg.Inputs.TraefikFile) +
+		len(cfg.Inputs.Certbot) +
+		len(cfg.Inputs.Caddy) +
+		len(cfg.Inputs.AcmeSh) +
+		len(cfg.Inputs.Kubernetes) +
+		len(cfg.Inputs.Vault) +
+		len(cfg.Inputs.Scan) +
+		len(cfg.Inputs.Keystore) +
+		len(cfg.Inputs.Command) +
+		len(cfg.Inputs.HaProxy) +
+		len(cfg.Inputs.Nginx) +
+		len(cfg.Inputs.Postfix) +
+		len(cfg.Inputs.Dovecot) +
+		len(cfg.Inputs.Lego) +
+		len(cfg.Inputs.Static.Certificates) +
+		len(cfg.Inputs.Static.Files) +
+		len(cfg.Inputs.Static.Records)
+
+	if inputs < 1 {
 		return fuel.AttachStackToError(errors.New("no inputs given"), 0)
 	}
 
@@ -96,16 +125,357 @@ func validateConfig(cfg *Config) fuel.ErrorWithStack {
 		if strings.TrimSpace(traefik.AcmeJson) == "" {
 			return fuel.AttachStackToError(fmt.Errorf("Traefik input #%d: acme.json path missing", i+1), 0)
 		}
+
+		for resolver, res := range traefik.Resolvers {
+			if res == nil {
+				continue
+			}
+
+			record := res.Records.Apply(cfg.Records)
+
+			if err := validateRecord(record); err != nil {
+				return fuel.AttachStackToError(
+					fmt.Errorf("Traefik input #%d: resolver %s: %s", i+1, resolver, err.Error()), 0,
+				)
+			}
+		}
+
+		if err := traefik.Validate(); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("Traefik input #%d: %s", i+1, err.Error()), 0)
+		}
+	}
+
+	for i, traefikFile := range cfg.Inputs.TraefikFile {
+		if strings.TrimSpace(traefikFile.Path) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("Traefik file provider input #%d: path missing", i+1), 0)
+		}
+
+		if err := traefikFile.Validate(); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("Traefik file provider input #%d: %s", i+1, err.Error()), 0)
+		}
+	}
+
+	for i, certbot := range cfg.Inputs.Certbot {
+		if strings.TrimSpace(certbot.ConfigDir) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("certbot input #%d: config dir missing", i+1), 0)
+		}
+
+		if err := certbot.Validate(); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("certbot input #%d: %s", i+1, err.Error()), 0)
+		}
+	}
+
+	for i, caddy := range cfg.Inputs.Caddy {
+		if strings.TrimSpace(caddy.DataDir) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("Caddy input #%d: data dir missing", i+1), 0)
+		}
+
+		if err := caddy.Validate(); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("Caddy input #%d: %s", i+1, err.Error()), 0)
+		}
+	}
+
+	for i, acmeSh := range cfg.Inputs.AcmeSh {
+		if strings.TrimSpace(acmeSh.CertHome) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("acme.sh input #%d: cert home missing", i+1), 0)
+		}
+
+		if err := acmeSh.Validate(); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("acme.sh input #%d: %s", i+1, err.Error()), 0)
+		}
+	}
+
+	for i, kubernetes := range cfg.Inputs.Kubernetes {
+		if err := kubernetes.Validate(); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("Kubernetes input #%d: %s", i+1, err.Error()), 0)
+		}
+	}
+
+	for i, vault := range cfg.Inputs.Vault {
+		if strings.TrimSpace(vault.Address) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("Vault input #%d: address missing", i+1), 0)
+		}
+
+		if vault.AppRole == nil {
+			if strings.TrimSpace(vault.TokenFile) == "" {
+				return fuel.AttachStackToError(fmt.Errorf("Vault input #%d: token file or AppRole missing", i+1), 0)
+			}
+		} else if strings.TrimSpace(vault.AppRole.RoleId) == "" || strings.TrimSpace(vault.AppRole.SecretIdFile) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("Vault input #%d: AppRole incomplete", i+1), 0)
+		}
+
+		if len(vault.Kv)+len(vault.Pki) < 1 {
+			return fuel.AttachStackToError(fmt.Errorf("Vault input #%d: no paths given", i+1), 0)
+		}
+
+		if vault.Interval < 0 {
+			return fuel.AttachStackToError(fmt.Errorf("Vault input #%d: interval must not be negative", i+1), 0)
+		}
+
+		if err := vault.Validate(); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("Vault input #%d: %s", i+1, err.Error()), 0)
+		}
+	}
+
+	for i, scan := range cfg.Inputs.Scan {
+		if len(scan.Targets) < 1 {
+			return fuel.AttachStackToError(fmt.Errorf("scan input #%d: no targets given", i+1), 0)
+		}
+
+		if scan.Interval < 0 || scan.Timeout < 0 {
+			return fuel.AttachStackToError(fmt.Errorf("scan input #%d: durations must not be negative", i+1), 0)
+		}
+
+		for j, target := range scan.Targets {
+			if _, _, err := net.SplitHostPort(target.Address); err != nil {
+				return fuel.AttachStackToError(fmt.Errorf("scan input #%d: target #%d: %s", i+1, j+1, err.Error()), 0)
+			}
+
+			switch target.StartTls {
+			case "", "smtp", "imap", "pop3", "xmpp", "xmpp-server":
+			default:
+				return fuel.AttachStackToError(fmt.Errorf(
+					"scan input #%d: target #%d: starttls must be one of smtp, imap, pop3, xmpp and xmpp-server", i+1, j+1,
+				), 0)
+			}
+		}
+
+		if err := scan.Validate(); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("scan input #%d: %s", i+1, err.Error()), 0)
+		}
+	}
+
+	for i, keystore := range cfg.Inputs.Keystore {
+		if strings.TrimSpace(keystore.File) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("keystore input #%d: file missing", i+1), 0)
+		}
+
+		if err := keystore.Validate(); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("keystore input #%d: %s", i+1, err.Error()), 0)
+		}
+	}
+
+	for i, command := range cfg.Inputs.Command {
+		if len(command.Argv) < 1 || strings.TrimSpace(command.Argv[0]) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("command input #%d: command missing", i+1), 0)
+		}
+
+		if command.Interval < 0 || command.Timeout < 0 {
+			return fuel.AttachStackToError(fmt.Errorf("command input #%d: durations must not be negative", i+1), 0)
+		}
+
+		if err := command.Validate(); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("command input #%d: %s", i+1, err.Error()), 0)
+		}
+	}
+
+	for i, haProxy := range cfg.Inputs.HaProxy {
+		if len(haProxy.CrtList)+len(haProxy.Crt) < 1 {
+			return fuel.AttachStackToError(fmt.Errorf("HAProxy input #%d: neither crt-lists nor crts given", i+1), 0)
+		}
+
+		if err := haProxy.Validate(); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("HAProxy input #%d: %s", i+1, err.Error()), 0)
+		}
+	}
+
+	for i, nginx := range cfg.Inputs.Nginx {
+		if strings.TrimSpace(nginx.Config) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("nginx input #%d: config missing", i+1), 0)
+		}
+
+		if err := nginx.Validate(); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("nginx input #%d: %s", i+1, err.Error()), 0)
+		}
+	}
+
+	for i, postfix := range cfg.Inputs.Postfix {
+		if strings.TrimSpace(postfix.ConfigDir) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("Postfix input #%d: config dir missing", i+1), 0)
+		}
+
+		if err := postfix.Validate(); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("Postfix input #%d: %s", i+1, err.Error()), 0)
+		}
 	}
 
+	for i, dovecot := range cfg.Inputs.Dovecot {
+		if strings.TrimSpace(dovecot.Config) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("Dovecot input #%d: config missing", i+1), 0)
+		}
+
+		if err := dovecot.Validate(); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("Dovecot input #%d: %s", i+1, err.Error()), 0)
+		}
+	}
+
+	for i, lego := range cfg.Inputs.Lego {
+		if strings.TrimSpace(lego.Path) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("lego input #%d: path missing", i+1), 0)
+		}
+
+		if err := lego.Validate(); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("lego input #%d: %s", i+1, err.Error()), 0)
+		}
+	}
+
+	for i, pem := range cfg.Inputs.Static.Certificates {
+		if strings.TrimSpace(pem) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("static certificate #%d: PEM missing", i+1), 0)
+		}
+
+		if _, err := ParseLeaf([]byte(pem)); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("static certificate #%d: %s", i+1, err.Error()), 0)
+		}
+	}
+
+	for i, file := range cfg.Inputs.Static.Files {
+		if strings.TrimSpace(file) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("static file #%d: path missing", i+1), 0)
+		}
+	}
+
+	for i, record := range cfg.Inputs.Static.Records {
+		if strings.TrimSpace(record.Service) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("static record #%d: service missing", i+1), 0)
+		}
+
+		if _, host := splitService(record.Service); host == "" {
+			return fuel.AttachStackToError(
+				fmt.Errorf("static record #%d: service must be like _25._tcp.mx.example.com", i+1), 0,
+			)
+		}
+
+		if strings.TrimSpace(record.Data) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("static record #%d: data missing", i+1), 0)
+		}
+
+		rec := record.RecordOverrides.Apply(cfg.Records)
+		if rec.CertUsage > 3 || rec.Selector > 1 || rec.MatchType > 2 {
+			return fuel.AttachStackToError(fmt.Errorf("static record #%d: bad usage, selector or match type", i+1), 0)
+		}
+
+		data, err := hex.DecodeString(record.Data)
+		if err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("static record #%d: %s", i+1, err.Error()), 0)
+		}
+
+		if expected := map[uint8]int{1: sha256.Size, 2: sha512.Size}[rec.MatchType]; len(data) < 1 ||
+			expected > 0 && len(data) != expected {
+			return fuel.AttachStackToError(fmt.Errorf("static record #%d: bad data length", i+1), 0)
+		}
+	}
+
+	if err := cfg.Inputs.Static.Validate(); err != nil {
+		return fuel.AttachStackToError(fmt.Errorf("static input: %s", err.Error()), 0)
+	}
+
+	for i, traefik := range cfg.Discovery.Traefik {
+		if strings.TrimSpace(traefik.Api) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("Traefik discovery #%d: API URL missing", i+1), 0)
+		}
+
+		if _, err := url.Parse(traefik.Api); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("Traefik discovery #%d: %s", i+1, err.Error()), 0)
+		}
+
+		if traefik.Interval < 0 {
+			return fuel.AttachStackToError(fmt.Errorf("Traefik discovery #%d: interval must not be negative", i+1), 0)
+		}
+
+		if err := traefik.Stale.Validate(); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("Traefik discovery #%d: %s", i+1, err.Error()), 0)
+		}
+	}
+
+	for i, docker := range cfg.Discovery.Docker {
+		if docker.Socket != "" && !filepath.IsAbs(docker.Socket) {
+			return fuel.AttachStackToError(fmt.Errorf("Docker discovery #%d: socket path must be absolute", i+1), 0)
+		}
+
+		if strings.ContainsAny(docker.LabelPrefix, " \t\r\n,=") {
+			return fuel.AttachStackToError(fmt.Errorf("Docker discovery #%d: bad label prefix", i+1), 0)
+		}
+
+		if err := docker.Stale.Validate(); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("Docker discovery #%d: %s", i+1, err.Error()), 0)
+		}
+	}
+
+	if cfg.Watch.PollInterval < 0 || cfg.Watch.ReReadInterval < 0 {
+		return fuel.AttachStackToError(errors.New("watch: intervals must not be negative"), 0)
+	}
+
+	if err := cfg.Domains.Validate(); err != nil {
+		return fuel.AttachStackToError(fmt.Errorf("domains: %s", err.Error()), 0)
+	}
+
+	if err := validateRecord(cfg.Records); err != nil {
+		return fuel.AttachStackToError(err, 0)
+	}
+
+	if err := validateTtls(cfg, inputs); err != nil {
+		return fuel.AttachStackToError(err, 0)
+	}
+
+	if len(cfg.Outputs.DeSec)+len(cfg.Outputs.Rfc2136) < 1 && !cfg.Outputs.Debug {
+		return fuel.AttachStackToError(errors.New("no outputs given"), 0)
+	}
+
+	for i, ds := range cfg.Outputs.DeSec {
+		if strings.TrimSpace(ds.Token) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("deSEC output #%d: token missing", i+1), 0)
+		}
+	}
+
+	for i, rf := range cfg.Outputs.Rfc2136 {
+		if strings.TrimSpace(rf.Server) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("RFC 2136 output #%d: server missing", i+1), 0)
+		}
+
+		if strings.TrimSpace(rf.Tsig.Name) == "" || rf.Tsig.Secret == "" {
+			return fuel.AttachStackToError(fmt.Errorf("RFC 2136 output #%d: TSIG key incomplete", i+1), 0)
+		}
+
+		if _, err := base64.StdEncoding.DecodeString(rf.Tsig.Secret); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("RFC 2136 output #%d: TSIG secret: %s", i+1, err.Error()), 0)
+		}
+
+		switch strings.ToLower(rf.Tsig.Algorithm) {
+		case "", "hmac-sha256", "hmac-sha512":
+		default:
+			return fuel.AttachStackToError(
+				fmt.Errorf("RFC 2136 output #%d: TSIG algorithm must be hmac-sha256 or hmac-sha512", i+1), 0,
+			)
+		}
+	}
+
+	for i, ct := range cfg.Ct {
+		if strings.TrimSpace(ct.Log) == "" {
+			return fuel.AttachStackToError(fmt.Errorf("CT log #%d: URL missing", i+1), 0)
+		}
+
+		if _, err := url.Parse(ct.Log); err != nil {
+			return fuel.AttachStackToError(fmt.Errorf("CT log #%d: %s", i+1, err.Error()), 0)
+		}
+
+		if ct.Interval < 0 || ct.Grace < 0 {
+			return fuel.AttachStackToError(fmt.Errorf("CT log #%d: durations must not be negative", i+1), 0)
+		}
+	}
+
+	return nil
+}
+
+func validateRecord(record Record) error {
 	for _, constraint := range []struct {
 		what     string
 		actual   uint8
 		expected []uint8
 	}{
-		{"cert_usage", cfg.Records.CertUsage, []uint8{1, 3}},
-		{"selector", cfg.Records.Selector, []uint8{0, 1}},
-		{"match_type", cfg.Records.MatchType, []uint8{0, 1, 2}},
+		{"cert_usage", record.CertUsage, []uint8{1, 3}},
+		{"selector", record.Selector, []uint8{0, 1}},
+		{"match_type", record.MatchType, []uint8{0, 1, 2}},
 	} {
 		ok := false
 		for _, ex := range constraint.expected {
@@ -116,17 +486,7 @@ func validateConfig(cfg *Config) fuel.ErrorWithStack {
 		}
 
 		if !ok {
-			return fuel.AttachStackToError(fmt.Errorf("%s must be one of %v", constraint.what, constraint.expected), 0)
-		}
-	}
-
-	if len(cfg.Outputs.DeSec) < 1 && !cfg.Outputs.Debug {
-		return fuel.AttachStackToError(errors.New("no outputs given"), 0)
-	}
-
-	for i, ds := range cfg.Outputs.DeSec {
-		if strings.TrimSpace(ds.Token) == "" {
-			return fuel.AttachStackToError(fmt.Errorf("deSEC output #%d: token missing", i+1), 0)
+			return fmt.Errorf("%s must be one of %v", constraint.what, constraint.expected)
 		}
 	}
 
@@ -146,3 +506,113 @@ func logTermSig(ctx context.Context, termSignal <-chan os.Signal) fuel.ErrorWith
 
 	return fuel.AttachStackToError(ctx.Err(), 0)
 }
+
+// validateTtls rejects different TTLs of Traefik resolvers', static and the global records
+// which may apply to the same services, i.e. the same RRsets, given the total number of inputs.
+func validateTtls(cfg *Config, inputs int) error {
+	type scope struct {
+		what string
+		ttl  uint32
+		// services are any if nil, e.g. discovered ones.
+		services map[string]struct{}
+		// host is any if empty.
+		host string
+	}
+
+	discoveries := len(cfg.Inputs.Nginx) + len(cfg.Inputs.Postfix) + len(cfg.Inputs.Dovecot) +
+		len(cfg.Discovery.Traefik) + len(cfg.Discovery.Docker)
+
+	servicesOf := func(ports *Ports) map[string]struct{} {
+		switch {
+		case ports != nil:
+			return (&Config{Ports: *ports}).Services()
+		case discoveries > 0:
+			return nil
+		default:
+			return cfg.Services()
+		}
+	}
+
+	var scopes []scope
+	for i, traefik := range cfg.Inputs.Traefik {
+		if len(traefik.Resolvers) < 1 {
+			continue
+		}
+
+		// Only the given resolvers are read, so these don't use the global records.
+		inputs--
+
+		resolvers := make([]string, 0, len(traefik.Resolvers))
+		for resolver := range traefik.Resolvers {
+			resolvers = append(resolvers, resolver)
+		}
+
+		sort.Strings(resolvers)
+
+		for _, resolver := range resolvers {
+			what := fmt.Sprintf("Traefik input #%d resolver %s", i+1, resolver)
+
+			if res := traefik.Resolvers[resolver]; res == nil {
+				scopes = append(scopes, scope{what, cfg.Records.Ttl, servicesOf(nil), ""})
+			} else {
+				scopes = append(scopes, scope{what, res.Records.Apply(cfg.Records).Ttl, servicesOf(res.Ports), ""})
+			}
+		}
+	}
+
+	for i, record := range cfg.Inputs.Static.Records {
+		// Published as is, so these don't use the global records.
+		inputs--
+
+		service, host := splitService(record.Service)
+		scopes = append(scopes, scope{
+			fmt.Sprintf("static record #%d", i+1), record.RecordOverrides.Apply(cfg.Records).Ttl,
+			map[string]struct{}{service: {}}, host,
+		})
+	}
+
+	if inputs > 0 {
+		scopes = append(scopes, scope{"global records", cfg.Records.Ttl, servicesOf(nil), ""})
+	}
+
+	for i, a := range scopes {
+		for _, b := range scopes[i+1:] {
+			if a.ttl != b.ttl && (a.host == "" || b.host == "" || a.host == b.host) &&
+				servicesOverlap(a.services, b.services) {
+				return fmt.Errorf(
+					"%s: TTL %d conflicts with TTL %d of %s for the same services (RRsets)", a.what, a.ttl, b.ttl, b.what,
+				)
+			}
+		}
+	}
+
+	return nil
+}
+
+// splitService splits e.g. _25._tcp.mx.example.com. into _25._tcp and mx.example.com.
+// The host is empty if name isn't like that.
+func splitService(name string) (service, host string) {
+	labels := strings.SplitN(strings.TrimSuffix(strings.ToLower(name), "."), ".", 3)
+	if len(labels) < 3 || len(labels[0]) < 2 || len(labels[1]) < 2 || labels[0][0] != '_' || labels[1][0] != '_' {
+		return "", ""
+	}
+
+	return labels[0] + "." + labels[1], labels[2]
+}
+
+// servicesOverlap tells whether a and b (nil means any) have services in common, e.g. _25._tcp and *._tcp.
+func servicesOverlap(a, b map[string]struct{}) bool {
+	if a == nil || b == nil {
+		return true
+	}
+
+	for x := range a {
+		for y := range b {
+			if x == y || (x[0] == '*' || y[0] == '*') && x[strings.Index(x, "._"):] == y[strings.Index(y, "._"):] {
+				return true
+			}
+		}
+	}
+
+	return false
+}